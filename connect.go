@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/AckeeCZ/goproxie/internal/dbclient"
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+	"github.com/AckeeCZ/goproxie/internal/store"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+const connectUsage = "Usage: goproxie connect [-sql_user=<user>] [-sql_database=<database>] -sql_instance=<name> <options> [-- <client args>]"
+
+// runConnect handles `goproxie connect`, launches psql, mysql or sqlcmd by the Cloud SQL instance type
+// once the tunnel is ready and closes the tunnel when the client exits.
+func runConnect(args []string) {
+	tunnelArgs, clientArgs, _ := splitCommand(args)
+	listeners, err := sqlproxy.ParseListeners(*flags.sqlInstance)
+	if err != nil || len(listeners) != 1 {
+		fmt.Println(connectUsage)
+		os.Exit(2)
+	}
+	defaults := readClientDefaults(listeners[0].Instance.ConnectionName)
+	if *flags.localPort == "" && *flags.sqlSocketDir == "" && listeners[0].LocalPort == 0 {
+		tunnelArgs = append(tunnelArgs, "-local_port="+localPortAuto)
+	}
+	os.Exit(runInTunnel(tunnelArgs, func(endpoints []tunnel.Endpoint) (*exec.Cmd, error) {
+		endpoint := endpoints[0]
+		bin, clientOptions, err := dbclient.Command(sqlproxy.CloudSQLInstanceType(endpoint.DatabaseType), endpoint.Address, defaults)
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.Command(bin, append(clientOptions, clientArgs...)...)
+		cmd.Env = append(os.Environ(), execEnv(endpoints)...)
+		return cmd, nil
+	}))
+}
+
+// readClientDefaults returns stored user and database of the instance overridden by -sql_user and -sql_database,
+// which are stored as the new defaults
+func readClientDefaults(instance string) dbclient.Defaults {
+	stored := []dbclient.Defaults{}
+	if err := store.UnmarshalKey(dbclient.KeyDefaults, &stored); err != nil {
+		log.Printf("Invalid %v setting: %v", dbclient.KeyDefaults, err)
+	}
+	defaults := dbclient.Find(stored, instance)
+	if *flags.sqlUser == "" && *flags.sqlDatabase == "" {
+		return defaults
+	}
+	if *flags.sqlUser != "" {
+		defaults.User = *flags.sqlUser
+	}
+	if *flags.sqlDatabase != "" {
+		defaults.Database = *flags.sqlDatabase
+	}
+	if *flags.noSave == false {
+		if err := store.Set(dbclient.KeyDefaults, dbclient.Replace(stored, defaults)); err != nil {
+			log.Printf("Couldn't store %v setting: %v", dbclient.KeyDefaults, err)
+		}
+	}
+	return defaults
+}
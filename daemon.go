@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/daemon"
+	"github.com/AckeeCZ/goproxie/internal/profile"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+// runDaemon handles `goproxie daemon [run|stop]`.
+// Without arguments the daemon is started in background.
+func runDaemon(args []string) error {
+	action := ""
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "":
+		return startDaemon()
+	case "run":
+		return serveDaemon()
+	case "stop":
+		if _, err := daemon.Send(daemon.Request{Command: daemon.CommandShutdown}); err != nil {
+			return err
+		}
+		fmt.Println("Daemon stopped")
+		return nil
+	default:
+		fmt.Println("Usage: goproxie daemon [run|stop]")
+		os.Exit(2)
+		return nil
+	}
+}
+
+// startDaemon executes `goproxie daemon run` detached from the terminal
+func startDaemon() error {
+	if daemon.IsRunning() {
+		fmt.Println("Daemon is already running")
+		return nil
+	}
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(daemon.LogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	cmd := exec.Command(bin, "daemon", "run")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Daemon started (pid %v), log: %v\n", cmd.Process.Pid, daemon.LogPath())
+	return nil
+}
+
+// serveDaemon runs the daemon in foreground
+func serveDaemon() error {
+	bin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	server := daemon.NewServer(bin, os.Stdout)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		server.Shutdown()
+	}()
+	return server.Serve()
+}
+
+// startTunnels handles `goproxie start <profile>` and `goproxie start -project=...`,
+// waits for the started tunnels to be ready if requested
+func startTunnels(args []string, wait bool, timeout time.Duration) error {
+	if len(args) > 1 {
+		fmt.Println("Usage: goproxie start [-wait_ready] [-wait_timeout=1m] <profile> | <options>")
+		os.Exit(2)
+	}
+	requests := []daemon.Request{}
+	if len(args) == 1 {
+		tunnels, err := profile.Load(args[0])
+		if err != nil {
+			return err
+		}
+		for _, t := range tunnels {
+			requests = append(requests, daemon.Request{Command: daemon.CommandStart, Name: t.Name, Args: t.Args})
+		}
+	} else {
+		requests = append(requests, daemon.Request{Command: daemon.CommandStart, Name: *flags.name, Args: tunnelArgs(flags.setArgs)})
+	}
+	names := []string{}
+	for _, request := range requests {
+		response, err := daemon.Send(request)
+		if err != nil {
+			return err
+		}
+		for _, t := range response.Tunnels {
+			fmt.Printf("Started %v\n", t.Name)
+			names = append(names, t.Name)
+		}
+	}
+	if wait {
+		return waitTunnels(names, timeout)
+	}
+	return nil
+}
+
+// tunnelArgs drops options of `start` itself, the rest is passed to the tunnel
+func tunnelArgs(setArgs []string) []string {
+	args := []string{}
+	for _, arg := range setArgs {
+		if !strings.HasPrefix(arg, "-wait_ready=") && !strings.HasPrefix(arg, "-wait_timeout=") {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+// How often `wait` polls the daemon
+const waitPollInterval = 500 * time.Millisecond
+
+// waitTunnels handles `goproxie wait <name>...`, blocks until all tunnels are ready.
+// Tunnel is ready when the daemon reports so and its local port passes the round-trip check.
+func waitTunnels(names []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, name := range names {
+		if err := waitTunnel(name, deadline); err != nil {
+			return err
+		}
+		fmt.Printf("%v is ready\n", name)
+	}
+	return nil
+}
+
+func waitTunnel(name string, deadline time.Time) error {
+	for {
+		response, err := daemon.Send(daemon.Request{Command: daemon.CommandList})
+		if err != nil {
+			return err
+		}
+		var status *daemon.TunnelStatus
+		for i := range response.Tunnels {
+			if response.Tunnels[i].Name == name {
+				status = &response.Tunnels[i]
+			}
+		}
+		if status == nil {
+			return fmt.Errorf("Tunnel %v not found", name)
+		}
+		reason := status.LastLine
+		switch status.State {
+		case tunnel.StateFailed, tunnel.StateStopped:
+			return fmt.Errorf("Tunnel %v %v: %v", name, status.State, status.LastLine)
+		case tunnel.StateReady:
+			// Cloud SQL tunnel is ready with the first instance, the others may be still connecting
+			instances, err := tunnelInstances(profile.ArgValue(status.Args, "sql_instance"), profile.ArgValue(status.Args, "local_port"), profile.ArgValue(status.Args, "sql_socket_dir"))
+			if err != nil {
+				return err
+			}
+			if err = checkEndpoints(status.Endpoints, len(instances)); err == nil {
+				return nil
+			}
+			reason = err.Error()
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for tunnel %v (%v): %v", name, status.State, reason)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// stopTunnel handles `goproxie stop <name>`
+func stopTunnel(args []string) error {
+	if len(args) == 0 {
+		fmt.Println("Usage: goproxie stop <name>")
+		os.Exit(2)
+	}
+	for _, name := range args {
+		if _, err := daemon.Send(daemon.Request{Command: daemon.CommandStop, Name: name}); err != nil {
+			return err
+		}
+		fmt.Printf("Stopped %v\n", name)
+	}
+	return nil
+}
+
+// listTunnels handles `goproxie ps`
+func listTunnels() error {
+	response, err := daemon.Send(daemon.Request{Command: daemon.CommandList})
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tPID\tARGS")
+	for _, t := range response.Tunnels {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", t.Name, t.State, t.Pid, strings.Join(t.Args, " "))
+	}
+	return w.Flush()
+}
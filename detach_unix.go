@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "syscall"
+
+// detachedProcAttr starts the process in a new session,
+// so it is not terminated together with the terminal.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processGroupProcAttr starts the process in a new process group of the same session,
+// so Ctrl+C in the terminal does not reach it but the parent decides when to stop it.
+func processGroupProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
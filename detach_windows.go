@@ -0,0 +1,18 @@
+package main
+
+import "syscall"
+
+// detachedProcess is DETACHED_PROCESS creation flag, missing in syscall package
+const detachedProcess = 0x00000008
+
+// detachedProcAttr starts the process without a console,
+// so it is not terminated together with the terminal.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+// processGroupProcAttr starts the process in a new process group of the same console,
+// so Ctrl+C in the console does not reach it but the parent decides when to stop it.
+func processGroupProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
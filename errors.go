@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
+)
+
+// Exit codes by error kind, 2 is reserved for usage errors
+var exitCodes = map[errs.Kind]int{
+	errs.NotAuthenticated: 3,
+	errs.PermissionDenied: 4,
+	errs.NotFound:         5,
+	errs.BinaryMissing:    6,
+	errs.Timeout:          7,
+	errs.Canceled:         130, // as shells report Ctrl+C
+}
+
+// hints tell the user how to resolve errors of the kind
+var hints = map[errs.Kind]string{
+	errs.NotAuthenticated: "You are not logged in to Google Cloud, run `gcloud auth login` and `gcloud auth application-default login`",
+	errs.PermissionDenied: "Your account is missing permissions, check IAM roles of the project or Kubernetes RBAC",
+	errs.NotFound:         "The resource was not found, check the project, cluster, namespace or instance name",
+	errs.BinaryMissing:    "Required binary was not found, install it or set -gcloud_path / -kubectl_path",
+	errs.Timeout:          "The request timed out, check your network connection or VPN and try again, -timeout sets the limit",
+}
+
+// exitCode returns the process exit code of the error.
+// Exit code of a failed child process (e.g. a command picked from history) is passed through.
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	if code, ok := exitCodes[errs.KindOf(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+// fatal prints the error with a hint how to resolve it and exits with the code of its kind
+func fatal(err error) {
+	loadingStop()
+	// Child process has already printed its error, Ctrl+C needs no explanation
+	if _, ok := err.(*exec.ExitError); !ok && errs.KindOf(err) != errs.Canceled {
+		if hint, ok := hints[errs.KindOf(err)]; ok {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/health"
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+const execUsage = "Usage: goproxie exec <options> -- <command> [args...]"
+
+// splitCommand splits arguments at `--` to tunnel options and the command
+func splitCommand(args []string) (tunnelArgs []string, command []string, ok bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
+
+// runExec handles `goproxie exec <options> -- <command>`, runs the command while the tunnel is open.
+func runExec(args []string) {
+	tunnelArgs, command, ok := splitCommand(args)
+	if !ok || len(command) == 0 {
+		fmt.Println(execUsage)
+		os.Exit(2)
+	}
+	os.Exit(runInTunnel(tunnelArgs, func(endpoints []tunnel.Endpoint) (*exec.Cmd, error) {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), execEnv(endpoints)...)
+		return cmd, nil
+	}))
+}
+
+// runInTunnel opens the tunnel as a child process, runs the command made for its endpoints once it is ready
+// and closes the tunnel when the command exits. Returns the command's exit code, 1 if the tunnel fails.
+func runInTunnel(tunnelArgs []string, command func(endpoints []tunnel.Endpoint) (*exec.Cmd, error)) int {
+	bin, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	name := *flags.name
+	if name == "" {
+		name = "tunnel"
+	}
+	// Own process group, so Ctrl+C reaches only the command and the tunnel stays open until the command exits
+	process, err := tunnel.StartWithProcAttr(bin, name, tunnelArgs, os.Stderr, processGroupProcAttr())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer func() {
+		process.Stop()
+		process.Wait()
+	}()
+
+	var mutex sync.Mutex
+	var cmd *exec.Cmd
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			mutex.Lock()
+			if cmd != nil {
+				// Command shares the terminal and has got Ctrl+C already, many commands force quit on a second one
+				if sig != os.Interrupt {
+					cmd.Process.Signal(sig)
+				}
+			}
+			// Terminal is gone, nobody uses the tunnel anymore
+			if cmd == nil || sig == syscall.SIGHUP {
+				process.Stop()
+			}
+			mutex.Unlock()
+		}
+	}()
+
+	instances := execInstances()
+	endpoints, err := waitProcess(process, len(instances), time.Now().Add(*flags.waitTimeout))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	sortEndpoints(endpoints, instances)
+
+	child, err := command(endpoints)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	mutex.Lock()
+	err = child.Start()
+	if err == nil {
+		cmd = child
+	}
+	mutex.Unlock()
+	if err == nil {
+		err = child.Wait()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// execInstances returns Cloud SQL instance of every expected endpoint in order, empty one for pod forward.
+func execInstances() []string {
+	instances, err := tunnelInstances(*flags.sqlInstance, *flags.localPort, *flags.sqlSocketDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return instances
+}
+
+// tunnelInstances returns Cloud SQL instance of every endpoint the tunnel of given options announces,
+// empty one for pod forward. Instance with both local port and Unix socket has two endpoints.
+func tunnelInstances(sqlInstance string, localPort string, sqlSocketDir string) ([]string, error) {
+	if sqlInstance == "" {
+		return []string{""}, nil
+	}
+	listeners, err := sqlproxy.ParseListeners(sqlInstance)
+	if err != nil {
+		return nil, err
+	}
+	instances := []string{}
+	for _, listener := range listeners {
+		name := listener.Instance.ConnectionName
+		if listener.LocalPort != 0 || localPort != "" || sqlSocketDir == "" {
+			instances = append(instances, name)
+		}
+		if sqlSocketDir != "" {
+			instances = append(instances, name)
+		}
+	}
+	return instances, nil
+}
+
+// waitProcess blocks until the tunnel announces count endpoints and their local ports pass the round-trip check
+func waitProcess(process *tunnel.Process, count int, deadline time.Time) ([]tunnel.Endpoint, error) {
+	for {
+		select {
+		case <-process.Done():
+			return nil, fmt.Errorf("Tunnel %v: %v", process.State(), process.LastLine())
+		default:
+		}
+		reason := process.LastLine()
+		endpoints := process.Endpoints()
+		if process.State() == tunnel.StateReady {
+			err := checkEndpoints(endpoints, count)
+			if err == nil {
+				return endpoints, nil
+			}
+			reason = err.Error()
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Timed out waiting for the tunnel (%v): %v", process.State(), reason)
+		}
+		time.Sleep(waitPollInterval)
+	}
+}
+
+// checkEndpoints passes once count endpoints are announced and all their local ports pass the round-trip check
+func checkEndpoints(endpoints []tunnel.Endpoint, count int) error {
+	if len(endpoints) < count {
+		return fmt.Errorf("%v of %v endpoints ready", len(endpoints), count)
+	}
+	for _, endpoint := range endpoints {
+		if !isSocket(endpoint.Address) {
+			if err := health.CheckPort(endpoint.Address); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sortEndpoints orders endpoints by their instances, as given in options, TCP ports before sockets
+func sortEndpoints(endpoints []tunnel.Endpoint, instances []string) {
+	index := func(endpoint tunnel.Endpoint) int {
+		for i, instance := range instances {
+			if instance == endpoint.Instance {
+				return i
+			}
+		}
+		return len(instances)
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		if index(endpoints[i]) != index(endpoints[j]) {
+			return index(endpoints[i]) < index(endpoints[j])
+		}
+		return !isSocket(endpoints[i].Address) && isSocket(endpoints[j].Address)
+	})
+}
+
+func isSocket(address string) bool {
+	return strings.HasPrefix(address, "/")
+}
+
+// execEnv returns environment variables describing the first TCP endpoint, the first Unix socket
+// and DSN of the first endpoint of a known database type.
+func execEnv(endpoints []tunnel.Endpoint) []string {
+	env := []string{}
+	hasAddress, hasSocket, hasDSN := false, false, false
+	for _, endpoint := range endpoints {
+		socket := isSocket(endpoint.Address)
+		host, port, _ := net.SplitHostPort(endpoint.Address)
+		if !socket && !hasAddress {
+			env = append(env, "GOPROXIE_HOST="+host, "GOPROXIE_PORT="+port)
+			hasAddress = true
+		}
+		if socket && !hasSocket {
+			env = append(env, "GOPROXIE_SOCKET="+endpoint.Address)
+			hasSocket = true
+		}
+		dbType := sqlproxy.CloudSQLInstanceType(endpoint.DatabaseType)
+		dsn := sqlproxy.DSN(dbType, endpoint.Address)
+		if dsn == "" || hasDSN {
+			continue
+		}
+		env = append(env, "DATABASE_URL="+dsn)
+		hasDSN = true
+		// Defaults of psql and mysql clients
+		switch {
+		case dbType == sqlproxy.TypePostgres && socket:
+			env = append(env, "PGHOST="+strings.TrimSuffix(endpoint.Address, "/.s.PGSQL.5432"))
+		case dbType == sqlproxy.TypePostgres:
+			env = append(env, "PGHOST="+host, "PGPORT="+port)
+		case dbType == sqlproxy.TypeMySQL:
+			env = append(env, "MYSQL_HOST="+host, "MYSQL_TCP_PORT="+port)
+		}
+	}
+	return env
+}
@@ -7,8 +7,15 @@ require (
 	github.com/AlecAivazis/survey/v2 v2.0.5
 	github.com/GoogleCloudPlatform/cloudsql-proxy v0.0.0-20200504171905-7e668d9ad0ba
 	github.com/briandowns/spinner v1.8.0
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/mattn/go-isatty v0.0.8
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/spf13/viper v1.6.2
-	golang.org/x/net v0.0.0-20200513185701-a91f0712d120
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	google.golang.org/api v0.26.0
+	k8s.io/api v0.18.19
+	k8s.io/apimachinery v0.18.19
+	k8s.io/client-go v0.18.19
+	sigs.k8s.io/yaml v1.2.0
 )
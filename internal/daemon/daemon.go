@@ -0,0 +1,243 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AckeeCZ/goproxie/internal/profile"
+	"github.com/AckeeCZ/goproxie/internal/store"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+// Control API commands
+const (
+	CommandStart    = "start"
+	CommandStop     = "stop"
+	CommandList     = "ps"
+	CommandShutdown = "shutdown"
+)
+
+// Request is a single control API call, sent as JSON over the control socket
+type Request struct {
+	Command string
+	Name    string
+	Args    []string
+}
+
+// TunnelStatus describes a tunnel owned by the daemon
+type TunnelStatus struct {
+	Name     string
+	State    tunnel.State
+	Pid      int
+	Args     []string
+	LastLine string
+	// Endpoints announced by the tunnel so far
+	Endpoints []tunnel.Endpoint
+}
+
+// Response of the control API
+type Response struct {
+	Error   string
+	Tunnels []TunnelStatus
+}
+
+// SocketPath returns path of the daemon control socket
+func SocketPath() string {
+	return path.Join(store.Dir(), "daemon.sock")
+}
+
+// LogPath returns path of the daemon log file with output of all tunnels
+func LogPath() string {
+	return path.Join(store.Dir(), "daemon.log")
+}
+
+// Send calls the running daemon.
+func Send(request Request) (*Response, error) {
+	conn, err := net.Dial("unix", SocketPath())
+	if err != nil {
+		return nil, fmt.Errorf("goproxie daemon is not running: %v", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, err
+	}
+	response := &Response{}
+	if err := json.NewDecoder(conn).Decode(response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return response, fmt.Errorf("%v", response.Error)
+	}
+	return response, nil
+}
+
+// IsRunning checks whether the daemon accepts control calls
+func IsRunning() bool {
+	_, err := Send(Request{Command: CommandList})
+	return err == nil
+}
+
+// Server owns the tunnels and serves the control API.
+type Server struct {
+	// goproxie executable tunnels are started with
+	bin     string
+	out     io.Writer
+	mutex   sync.Mutex
+	tunnels map[string]*tunnel.Process
+	quit    chan struct{}
+}
+
+// NewServer creates a daemon starting tunnels with the given goproxie binary.
+// Output of all tunnels is written to out.
+func NewServer(bin string, out io.Writer) *Server {
+	return &Server{bin: bin, out: out, tunnels: make(map[string]*tunnel.Process), quit: make(chan struct{})}
+}
+
+// Serve listens on the control socket until shutdown is requested.
+// All tunnels are stopped before returning.
+func (s *Server) Serve() error {
+	if IsRunning() {
+		return fmt.Errorf("goproxie daemon is already running")
+	}
+	// Socket file left by a daemon that did not exit cleanly
+	os.Remove(SocketPath())
+	listener, err := net.Listen("unix", SocketPath())
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-s.quit
+		listener.Close()
+	}()
+	log.Printf("Listening on %v", SocketPath())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				s.stopAll()
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+// Shutdown stops all tunnels and makes Serve return.
+func (s *Server) Shutdown() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	request := Request{}
+	response := Response{}
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response.Error = err.Error()
+	} else if err := s.dispatch(request, &response); err != nil {
+		response.Error = err.Error()
+	}
+	json.NewEncoder(conn).Encode(response)
+}
+
+func (s *Server) dispatch(request Request, response *Response) error {
+	switch request.Command {
+	case CommandStart:
+		status, err := s.start(request.Name, request.Args)
+		if err != nil {
+			return err
+		}
+		response.Tunnels = []TunnelStatus{status}
+	case CommandStop:
+		status, err := s.stop(request.Name)
+		if err != nil {
+			return err
+		}
+		response.Tunnels = []TunnelStatus{status}
+	case CommandList:
+		response.Tunnels = s.list()
+	case CommandShutdown:
+		response.Tunnels = s.list()
+		s.Shutdown()
+	default:
+		return fmt.Errorf("unknown command %q", request.Command)
+	}
+	return nil
+}
+
+func (s *Server) start(name string, args []string) (TunnelStatus, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if name == "" {
+		name = profile.TunnelName(args, len(s.tunnels)+1)
+	}
+	// Exited tunnel is kept for `ps` to show why, until replaced
+	if existing, ok := s.tunnels[name]; ok && existing.State() != tunnel.StateFailed && existing.State() != tunnel.StateStopped {
+		return TunnelStatus{}, fmt.Errorf("tunnel %q already exists, stop it first or use -name", name)
+	}
+	process, err := tunnel.Start(s.bin, name, args, s.out)
+	if err != nil {
+		return TunnelStatus{}, err
+	}
+	log.Printf("Started tunnel %v: %v", name, strings.Join(args, " "))
+	s.tunnels[name] = process
+	return status(process), nil
+}
+
+func (s *Server) stop(name string) (TunnelStatus, error) {
+	s.mutex.Lock()
+	process, ok := s.tunnels[name]
+	delete(s.tunnels, name)
+	s.mutex.Unlock()
+	if !ok {
+		return TunnelStatus{}, fmt.Errorf("no tunnel named %q", name)
+	}
+	process.Stop()
+	process.Wait()
+	log.Printf("Stopped tunnel %v", name)
+	return status(process), nil
+}
+
+func (s *Server) stopAll() {
+	for _, tunnel := range s.list() {
+		s.stop(tunnel.Name)
+	}
+}
+
+func (s *Server) list() []TunnelStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	tunnels := []TunnelStatus{}
+	for _, process := range s.tunnels {
+		tunnels = append(tunnels, status(process))
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Name < tunnels[j].Name })
+	return tunnels
+}
+
+func status(process *tunnel.Process) TunnelStatus {
+	return TunnelStatus{
+		Name:      process.Name,
+		State:     process.State(),
+		Pid:       process.Pid(),
+		Args:      process.Args,
+		LastLine:  process.LastLine(),
+		Endpoints: process.Endpoints(),
+	}
+}
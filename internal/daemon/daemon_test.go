@@ -0,0 +1,96 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+// Tunnels are run by sh, arguments are `-c <script>` and `-no-save` becomes $0
+func newTestServer() *Server {
+	return NewServer("/bin/sh", ioutil.Discard)
+}
+
+func waitState(t *testing.T, s *Server, name string, state tunnel.State) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range s.list() {
+			if status.Name == name && status.State == state {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Tunnel %v did not get %v, tunnels: %v", name, state, s.list())
+}
+
+func TestDispatch(t *testing.T) {
+	s := newTestServer()
+	defer s.stopAll()
+	response := &Response{}
+	err := s.dispatch(Request{Command: CommandStart, Name: "api", Args: []string{"-c", "echo 'Forwarding from 127.0.0.1:8080 -> 80'; exec sleep 30"}}, response)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(response.Tunnels) != 1 || response.Tunnels[0].Name != "api" || response.Tunnels[0].Pid == 0 {
+		t.Errorf("Expected started tunnel `%v`, got `%v`", "api", response.Tunnels)
+	}
+	waitState(t, s, "api", tunnel.StateReady)
+
+	if err := s.dispatch(Request{Command: CommandStart, Name: "api", Args: []string{"-c", "exec sleep 30"}}, &Response{}); err == nil {
+		t.Errorf("Expected error starting running tunnel `%v` again", "api")
+	}
+
+	response = &Response{}
+	if err := s.dispatch(Request{Command: CommandList}, response); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(response.Tunnels) != 1 || response.Tunnels[0].State != tunnel.StateReady {
+		t.Errorf("Expected `%v` does not match result `%v`", "[api ready]", response.Tunnels)
+	}
+	if endpoints := response.Tunnels[0].Endpoints; len(endpoints) != 1 || endpoints[0].Address != "127.0.0.1:8080" {
+		t.Errorf("Expected `%v` does not match result `%v`", "127.0.0.1:8080", endpoints)
+	}
+
+	response = &Response{}
+	if err := s.dispatch(Request{Command: CommandStop, Name: "api"}, response); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(response.Tunnels) != 1 || response.Tunnels[0].State != tunnel.StateFailed {
+		t.Errorf("Expected interrupted tunnel, got `%v`", response.Tunnels)
+	}
+	if len(s.list()) != 0 {
+		t.Errorf("Expected no tunnels, got `%v`", s.list())
+	}
+	if err := s.dispatch(Request{Command: CommandStop, Name: "api"}, &Response{}); err == nil {
+		t.Errorf("Expected error stopping unknown tunnel `%v`", "api")
+	}
+
+	if err := s.dispatch(Request{Command: "restart"}, &Response{}); err == nil {
+		t.Errorf("Expected error for unknown command")
+	}
+}
+
+func TestStartReplacesExitedTunnel(t *testing.T) {
+	s := newTestServer()
+	defer s.stopAll()
+	if err := s.dispatch(Request{Command: CommandStart, Name: "job", Args: []string{"-c", "echo broken; exit 1"}}, &Response{}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	waitState(t, s, "job", tunnel.StateFailed)
+	if status := s.list()[0]; status.LastLine != "broken" {
+		t.Errorf("Expected `%v` does not match result `%v`", "broken", status.LastLine)
+	}
+	if err := s.dispatch(Request{Command: CommandStart, Name: "job", Args: []string{"-c", "exec sleep 30"}}, &Response{}); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	waitState(t, s, "job", tunnel.StateStarting)
+	if len(s.list()) != 1 {
+		t.Errorf("Expected `%v` does not match result `%v`", 1, len(s.list()))
+	}
+}
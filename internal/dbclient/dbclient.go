@@ -0,0 +1,88 @@
+package dbclient
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+)
+
+// KeyDefaults defines the store setting of user and database per Cloud SQL instance
+const KeyDefaults = "sql.clients"
+
+// Defaults are user and database the client connects to the instance with
+type Defaults struct {
+	Instance string `mapstructure:"instance" json:"instance"`
+	User     string `mapstructure:"user" json:"user,omitempty"`
+	Database string `mapstructure:"database" json:"database,omitempty"`
+}
+
+// Find returns defaults of the instance, empty if there are none
+func Find(defaults []Defaults, instance string) Defaults {
+	for _, d := range defaults {
+		if d.Instance == instance {
+			return d
+		}
+	}
+	return Defaults{Instance: instance}
+}
+
+// Replace returns defaults with those of d.Instance replaced by d, appended if missing
+func Replace(defaults []Defaults, d Defaults) []Defaults {
+	result := []Defaults{}
+	for _, existing := range defaults {
+		if existing.Instance != d.Instance {
+			result = append(result, existing)
+		}
+	}
+	return append(result, d)
+}
+
+// Command returns the client binary (psql, mysql or sqlcmd) and its arguments connecting to the local endpoint.
+// Address is host:port or Unix socket path, user and database are optional.
+func Command(dbType sqlproxy.CloudSQLInstanceType, address string, d Defaults) (string, []string, error) {
+	socket := strings.HasPrefix(address, "/")
+	host, port := "", ""
+	if !socket {
+		var err error
+		if host, port, err = net.SplitHostPort(address); err != nil {
+			return "", nil, err
+		}
+	}
+	switch dbType {
+	case sqlproxy.TypePostgres:
+		args := []string{"-h", host, "-p", port}
+		if socket {
+			// psql takes socket directory, file name is derived from the port
+			args = []string{"-h", filepath.Dir(address)}
+		}
+		return "psql", append(args, optional("-U", d.User, "-d", d.Database)...), nil
+	case sqlproxy.TypeMySQL:
+		args := []string{"-h", host, "-P", port, "--protocol=TCP"}
+		if socket {
+			args = []string{"--socket=" + address}
+		}
+		return "mysql", append(args, optional("-u", d.User, "-D", d.Database)...), nil
+	case sqlproxy.TypeSQLServer:
+		if socket {
+			return "", nil, fmt.Errorf("sqlcmd does not support Unix sockets, use -local_port")
+		}
+		args := []string{"-S", fmt.Sprintf("tcp:%v,%v", host, port)}
+		return "sqlcmd", append(args, optional("-U", d.User, "-d", d.Database)...), nil
+	default:
+		return "", nil, fmt.Errorf("unknown database type %v, no client to launch", dbType)
+	}
+}
+
+// optional returns option name and value pairs with non-empty value
+func optional(pairs ...string) []string {
+	args := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			args = append(args, pairs[i], pairs[i+1])
+		}
+	}
+	return args
+}
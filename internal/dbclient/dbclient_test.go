@@ -0,0 +1,58 @@
+package dbclient
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+)
+
+func TestCommand(t *testing.T) {
+	items := []struct {
+		dbType   sqlproxy.CloudSQLInstanceType
+		address  string
+		defaults Defaults
+		expected string
+	}{
+		{sqlproxy.TypePostgres, "127.0.0.1:5433", Defaults{User: "app", Database: "orders"}, "psql -h 127.0.0.1 -p 5433 -U app -d orders"},
+		{sqlproxy.TypePostgres, "/tmp/cloudsql/acme:europe-west1:db/.s.PGSQL.5432", Defaults{}, "psql -h /tmp/cloudsql/acme:europe-west1:db"},
+		{sqlproxy.TypeMySQL, "[::1]:3306", Defaults{User: "root"}, "mysql -h ::1 -P 3306 --protocol=TCP -u root"},
+		{sqlproxy.TypeMySQL, "/tmp/cloudsql/acme:europe-west1:mysql", Defaults{Database: "shop"}, "mysql --socket=/tmp/cloudsql/acme:europe-west1:mysql -D shop"},
+		{sqlproxy.TypeSQLServer, "127.0.0.1:1433", Defaults{User: "sqlserver"}, "sqlcmd -S tcp:127.0.0.1,1433 -U sqlserver"},
+	}
+	for _, item := range items {
+		bin, args, err := Command(item.dbType, item.address, item.defaults)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result := strings.Join(append([]string{bin}, args...), " "); result != item.expected {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+	if _, _, err := Command(sqlproxy.TypeUnknown, "127.0.0.1:3307", Defaults{}); err == nil {
+		t.Errorf("Expected error for unknown database type")
+	}
+	if _, _, err := Command(sqlproxy.TypeSQLServer, "/tmp/cloudsql/acme:europe-west1:mssql", Defaults{}); err == nil {
+		t.Errorf("Expected error for SQL Server Unix socket")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	defaults := []Defaults{{Instance: "a", User: "app"}, {Instance: "b", Database: "shop"}}
+	if result := Find(defaults, "b"); result.Database != "shop" {
+		t.Errorf("Expected `%v` does not match result `%v`", "shop", result.Database)
+	}
+	if result := Find(defaults, "c"); result != (Defaults{Instance: "c"}) {
+		t.Errorf("Expected empty defaults, got `%v`", result)
+	}
+	result := Replace(defaults, Defaults{Instance: "a", User: "admin"})
+	expectedItems := []Defaults{{Instance: "b", Database: "shop"}, {Instance: "a", User: "admin"}}
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected `%v` does not match result `%v`", expectedItems, result)
+	}
+	for i, expectedItem := range expectedItems {
+		if expectedItem != result[i] {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, result[i])
+		}
+	}
+}
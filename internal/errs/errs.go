@@ -0,0 +1,110 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Kind classifies errors of external commands and APIs, so callers can recover or show a friendly message
+type Kind string
+
+const (
+	// Unknown error, not classified
+	Unknown Kind = "unknown"
+	// NotAuthenticated gcloud or Application Default Credentials are missing or expired
+	NotAuthenticated Kind = "not-authenticated"
+	// PermissionDenied the account lacks permission to the project, cluster or instance
+	PermissionDenied Kind = "permission-denied"
+	// NotFound the project, cluster, namespace, pod or instance does not exist
+	NotFound Kind = "not-found"
+	// BinaryMissing gcloud or kubectl executable is not installed
+	BinaryMissing Kind = "binary-missing"
+	// Timeout the operation did not finish in time
+	Timeout Kind = "timeout"
+	// Canceled the operation was canceled by the user, e.g. Ctrl+C
+	Canceled Kind = "canceled"
+)
+
+// Error is a classified error of an operation, e.g. a gcloud command or an API request
+type Error struct {
+	Kind Kind
+	// Op is the failed operation, e.g. `gcloud projects list`
+	Op string
+	// Stderr is the error output of the failed command, if any
+	Stderr string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	message := e.Err.Error()
+	if e.Op != "" {
+		message = e.Op + ": " + message
+	}
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		message += "\n" + stderr
+	}
+	return message
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns error of the given kind
+func New(kind Kind, op string, err error) *Error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// KindOf returns kind of the error or of the first classified error it wraps, Unknown otherwise
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return Unknown
+}
+
+// Patterns of error messages and stderr of gcloud, kubectl and Google APIs, lower case
+var patterns = []struct {
+	kind     Kind
+	contains []string
+}{
+	{Timeout, []string{"deadline exceeded", "timed out", "i/o timeout"}},
+	{NotAuthenticated, []string{"could not find default credentials", "do not currently have an active account", "reauthentication", "unauthenticated", "unauthorized", "invalid_grant", "error 401", "401 unauthorized", "code=401", "gcloud auth login"}},
+	{PermissionDenied, []string{"permission_denied", "permission denied", "forbidden", "error 403", "403 forbidden", "code=403", "does not have permission", "not authorized", "not_authorized"}},
+	{NotFound, []string{"not_found", "not found", "error 404", "404 not found", "code=404"}},
+}
+
+// isBinaryMissing reports whether the executable of the command was not found or could not be executed
+func isBinaryMissing(err error) bool {
+	var execErr *exec.Error
+	var pathErr *os.PathError
+	return errors.As(err, &execErr) || (errors.As(err, &pathErr) && pathErr.Op == "fork/exec" && os.IsNotExist(pathErr))
+}
+
+// Classify returns error of the failed operation, its kind is inferred from the error and stderr
+func Classify(op string, stderr string, err error) *Error {
+	var netErr net.Error
+	switch {
+	case isBinaryMissing(err):
+		return &Error{Kind: BinaryMissing, Op: op, Stderr: stderr, Err: err}
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return &Error{Kind: Timeout, Op: op, Stderr: stderr, Err: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Kind: Canceled, Op: op, Stderr: stderr, Err: err}
+	}
+	text := strings.ToLower(stderr + "\n" + err.Error())
+	for _, pattern := range patterns {
+		for _, item := range pattern.contains {
+			if strings.Contains(text, item) {
+				return &Error{Kind: pattern.kind, Op: op, Stderr: stderr, Err: err}
+			}
+		}
+	}
+	return &Error{Kind: Unknown, Op: op, Stderr: stderr, Err: err}
+}
@@ -0,0 +1,59 @@
+package errs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	items := []struct {
+		stderr   string
+		err      error
+		expected Kind
+	}{
+		{"", &exec.Error{Name: "gcloud", Err: exec.ErrNotFound}, BinaryMissing},
+		{"", &os.PathError{Op: "fork/exec", Path: "/opt/kubectl", Err: syscall.ENOENT}, BinaryMissing},
+		{"", &os.PathError{Op: "open", Path: "/root/.config/goproxie/store.json", Err: syscall.ENOENT}, Unknown},
+		{"ERROR: (gcloud.projects.list) You do not currently have an active account selected.", errors.New("exit status 1"), NotAuthenticated},
+		{"", errors.New("google: could not find default credentials. See https://developers.google.com/accounts/docs/application-default-credentials for more information."), NotAuthenticated},
+		{"ERROR: (gcloud.container.clusters.list) ResponseError: code=403, message=Required \"container.clusters.list\" permission(s). PERMISSION_DENIED", errors.New("exit status 1"), PermissionDenied},
+		{"Error from server (Forbidden): pods is forbidden: User cannot list resource \"pods\"", errors.New("exit status 1"), PermissionDenied},
+		{"Error from server (NotFound): namespaces \"acme\" not found", errors.New("exit status 1"), NotFound},
+		{"", errors.New("GET projects/acme/instances failed with 404 Not Found: {}"), NotFound},
+		{"Unable to connect to the server: dial tcp 10.0.0.1:443: i/o timeout", errors.New("exit status 1"), Timeout},
+		{"", fmt.Errorf("gcloud projects list: %w", context.DeadlineExceeded), Timeout},
+		{"", context.Canceled, Canceled},
+		{"error: unknown flag: --foo", errors.New("exit status 1"), Unknown},
+	}
+	for _, item := range items {
+		if result := Classify("op", item.stderr, item.err).Kind; result != item.expected {
+			t.Errorf("Expected `%v` does not match result `%v` for %q", item.expected, result, item.stderr+item.err.Error())
+		}
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	err := fmt.Errorf("loading clusters: %w", New(PermissionDenied, "gcloud container clusters list", errors.New("exit status 1")))
+	if result := KindOf(err); result != PermissionDenied {
+		t.Errorf("Expected `%v` does not match result `%v`", PermissionDenied, result)
+	}
+	if result := KindOf(errors.New("plain")); result != Unknown {
+		t.Errorf("Expected `%v` does not match result `%v`", Unknown, result)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := Classify("kubectl get pods", "error: You must be logged in to the server (Unauthorized)\n", errors.New("exit status 1"))
+	expected := "kubectl get pods: exit status 1\nerror: You must be logged in to the server (Unauthorized)"
+	if err.Error() != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, err.Error())
+	}
+	if err.Kind != NotAuthenticated {
+		t.Errorf("Expected `%v` does not match result `%v`", NotAuthenticated, err.Kind)
+	}
+}
@@ -1,6 +1,9 @@
 package gcloud
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/AckeeCZ/goproxie/internal/util"
@@ -16,8 +19,12 @@ func SetGcloudPath(path string) {
 }
 
 // ProjectsList returns the list of google cloud projects
-func ProjectsList() []string {
-	return strings.Fields(runCommand(gcloudPath, "projects", "list", "--format", "value(projectId)"))
+func ProjectsList(ctx context.Context) ([]string, error) {
+	out, err := runCommand(ctx, gcloudPath, "projects", "list", "--format", "value(projectId)")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
 }
 
 // Cluster structure
@@ -26,29 +33,55 @@ type Cluster struct {
 	Location string
 }
 
+// clusterItem is a cluster of `gcloud container clusters list --format json`
+type clusterItem struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+	// Zone is set by older gcloud versions instead of location
+	Zone string `json:"zone"`
+}
+
 //ContainerClustersList returns the list of GCP clusters
-func ContainerClustersList(projectID string) []*Cluster {
-	out := runCommand(gcloudPath, "container", "clusters", "list", "--format", "value(name, location)", "--project", projectID)
-	lines := strings.Split(out, "\n")
+func ContainerClustersList(ctx context.Context, projectID string) ([]*Cluster, error) {
+	out, err := runCommand(ctx, gcloudPath, "container", "clusters", "list", "--format", "json", "--project", projectID)
+	if err != nil {
+		return nil, err
+	}
+	return parseClustersList(out)
+}
+
+func parseClustersList(out string) ([]*Cluster, error) {
+	items := []clusterItem{}
+	if err := json.Unmarshal([]byte(out), &items); err != nil {
+		return nil, fmt.Errorf("couldn't parse gcloud clusters list: %v", err)
+	}
 	clusters := []*Cluster{}
-	for _, line := range lines {
-		split := strings.Fields(line)
-		if len(split) >= 2 {
-			clusters = append(clusters, &Cluster{Name: split[0], Location: split[1]})
+	for _, item := range items {
+		location := item.Location
+		if location == "" {
+			location = item.Zone
 		}
+		clusters = append(clusters, &Cluster{Name: item.Name, Location: location})
 	}
-	return clusters
-	// return Cluster{name: results[0], location: results[1]}
+	return clusters, nil
 }
 
 // gcloud config set project PROJECT
 
 //SetDefaultProject sets the default Project for the gcloud cli
-func SetDefaultProject(projectID string) {
-	util.RunSilentCommand(gcloudPath, "config", "set", "project", projectID)
+func SetDefaultProject(ctx context.Context, projectID string) error {
+	_, err := runCommand(ctx, gcloudPath, "config", "set", "project", projectID)
+	return err
 }
 
-// GetClusterCredentials gets credentials for the given GCP cluster
-func GetClusterCredentials(projectID string, cluster *Cluster) {
-	util.RunSilentCommand(gcloudPath, "container", "clusters", "get-credentials", cluster.Name, "--project", projectID, "--zone", cluster.Location)
+// GetClusterCredentials gets credentials for the given GCP cluster.
+// Credentials are written to the given kubeconfig file, so the user's
+// current kubectl context is not switched. Empty path means the default kubeconfig.
+func GetClusterCredentials(ctx context.Context, projectID string, cluster *Cluster, kubeconfig string) error {
+	env := []string{}
+	if kubeconfig != "" {
+		env = append(env, "KUBECONFIG="+kubeconfig)
+	}
+	_, err := util.RunCommandWithEnv(ctx, env, gcloudPath, "container", "clusters", "get-credentials", cluster.Name, "--project", projectID, "--zone", cluster.Location)
+	return err
 }
@@ -1,7 +1,11 @@
 package gcloud
 
 import (
+	"context"
+	"errors"
 	"testing"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
 )
 
 // Exact command results
@@ -9,13 +13,19 @@ var mockProjectsList = `acme-sro-development
 snackee
 infrastructure-1188
 `
-var mockClustersList = `production	europe-west1-d
+
+// Trimmed `gcloud container clusters list --format json` output, older gcloud sets zone only
+var mockClustersList = `[
+  {"name": "production", "location": "europe-west1-d", "zone": "europe-west1-d", "status": "RUNNING"},
+  {"name": "staging", "zone": "europe-west1-b"},
+  {"name": "autopilot", "location": "europe-west1"}
+]
 `
 
 func mockRunCommand(mockResponse string) func() {
 	originalRunCommand := runCommand
-	runCommand = func(cmd string, args ...string) string {
-		return mockResponse
+	runCommand = func(_ context.Context, cmd string, args ...string) (string, error) {
+		return mockResponse, nil
 	}
 	return func() {
 		runCommand = originalRunCommand
@@ -25,7 +35,10 @@ func mockRunCommand(mockResponse string) func() {
 func TestProjectsList(t *testing.T) {
 	unmock := mockRunCommand(mockProjectsList)
 	defer unmock()
-	result := ProjectsList()
+	result, err := ProjectsList(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	expectedItems := []string{
 		"acme-sro-development",
 		"snackee",
@@ -44,12 +57,14 @@ func TestProjectsList(t *testing.T) {
 func TestContainerClustersList(t *testing.T) {
 	unmock := mockRunCommand(mockClustersList)
 	defer unmock()
-	result := ContainerClustersList("anyproject")
+	result, err := ContainerClustersList(context.Background(), "anyproject")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	expectedItems := []*Cluster{
-		{
-			Name:     "production",
-			Location: "europe-west1-d",
-		},
+		{Name: "production", Location: "europe-west1-d"},
+		{Name: "staging", Location: "europe-west1-b"},
+		{Name: "autopilot", Location: "europe-west1"},
 	}
 	if len(expectedItems) != len(result) {
 		t.Errorf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
@@ -60,3 +75,38 @@ func TestContainerClustersList(t *testing.T) {
 		}
 	}
 }
+
+func TestContainerClustersListOddOutput(t *testing.T) {
+	items := []struct {
+		out      string
+		expected int
+		err      bool
+	}{
+		{`[]`, 0, false},
+		{"Listed 0 items.\n", 0, true},
+		{`production	europe-west1-d`, 0, true},
+	}
+	for _, item := range items {
+		unmock := mockRunCommand(item.out)
+		result, err := ContainerClustersList(context.Background(), "anyproject")
+		unmock()
+		if (err != nil) != item.err {
+			t.Errorf("Expected error `%v` does not match result `%v` for %q", item.err, err, item.out)
+		}
+		if len(result) != item.expected {
+			t.Errorf("Expected len `%v` does not match result `%v` for %q", item.expected, len(result), item.out)
+		}
+	}
+}
+
+func TestContainerClustersListError(t *testing.T) {
+	originalRunCommand := runCommand
+	runCommand = func(_ context.Context, cmd string, args ...string) (string, error) {
+		return "", errs.Classify("gcloud container clusters list", "ERROR: (gcloud.container.clusters.list) ResponseError: code=403, message=Required \"container.clusters.list\" permission(s).", errors.New("exit status 1"))
+	}
+	defer func() { runCommand = originalRunCommand }()
+	_, err := ContainerClustersList(context.Background(), "anyproject")
+	if kind := errs.KindOf(err); kind != errs.PermissionDenied {
+		t.Errorf("Expected `%v` does not match result `%v`", errs.PermissionDenied, kind)
+	}
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tunnel is readiness of a local listener
+type Tunnel struct {
+	// Type is pod or sql
+	Type string `json:"type"`
+	// Listener is the local address
+	Listener string `json:"listener"`
+	// Target is the pod or Cloud SQL instance
+	Target string `json:"target,omitempty"`
+	Ready  bool   `json:"ready"`
+	// Detail is the reason the tunnel is not ready
+	Detail string `json:"detail,omitempty"`
+}
+
+// Status is the health endpoint response
+type Status struct {
+	Ready   bool     `json:"ready"`
+	Tunnels []Tunnel `json:"tunnels"`
+}
+
+var (
+	mutex   sync.Mutex
+	tunnels = map[string]Tunnel{}
+)
+
+// Set registers or replaces the tunnel
+func Set(tunnel Tunnel) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tunnels[tunnel.Listener] = tunnel
+}
+
+// Update updates readiness of the registered tunnel, returns false if the tunnel is not registered
+func Update(listener string, ready bool, detail string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	tunnel, ok := tunnels[listener]
+	if !ok {
+		return false
+	}
+	tunnel.Ready = ready
+	tunnel.Detail = detail
+	tunnels[listener] = tunnel
+	return true
+}
+
+// Remove unregisters the tunnel of the closed listener
+func Remove(listener string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(tunnels, listener)
+}
+
+// Current returns readiness of all tunnels, ready when there is at least one and all are ready
+func Current() Status {
+	mutex.Lock()
+	defer mutex.Unlock()
+	status := Status{Ready: len(tunnels) > 0, Tunnels: []Tunnel{}}
+	for _, tunnel := range tunnels {
+		status.Ready = status.Ready && tunnel.Ready
+		status.Tunnels = append(status.Tunnels, tunnel)
+	}
+	sort.Slice(status.Tunnels, func(i, j int) bool { return status.Tunnels[i].Listener < status.Tunnels[j].Listener })
+	return status
+}
+
+// Handler responds with readiness of all tunnels as JSON, 503 Service Unavailable unless all are ready
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := Current()
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// How long the round-trip check waits for the tunnel to close the connection
+const roundTripTimeout = 500 * time.Millisecond
+
+// CheckPort checks the local port accepts a connection and the tunnel keeps it open.
+// Tunnels close local connections they fail to forward.
+func CheckPort(address string) error {
+	conn, err := net.DialTimeout("tcp", address, roundTripTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(roundTripTimeout))
+	// Database servers may greet first, others wait for the client
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil
+	}
+	if err == io.EOF {
+		return errors.New("connection closed by the tunnel")
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package health
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	Set(Tunnel{Type: "sql", Listener: "127.0.0.1:5432", Target: "acme:europe-west1:db", Detail: "connecting"})
+	defer Remove("127.0.0.1:5432")
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected `%v` does not match result `%v`", http.StatusServiceUnavailable, recorder.Code)
+	}
+	if !Update("127.0.0.1:5432", true, "") {
+		t.Fatal("Expected registered tunnel to be updated")
+	}
+	recorder = httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected `%v` does not match result `%v`", http.StatusOK, recorder.Code)
+	}
+	if Update("127.0.0.1:5433", true, "") {
+		t.Error("Expected unknown tunnel not to be updated")
+	}
+}
+
+func TestCheckPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	if err := CheckPort(listener.Addr().String()); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	(<-accepted).Close()
+
+	// Tunnel failing to forward closes the connection right away
+	go func() {
+		conn, _ := listener.Accept()
+		conn.Close()
+	}()
+	if err := CheckPort(listener.Addr().String()); err == nil {
+		t.Error("Expected error for closed connection")
+	}
+}
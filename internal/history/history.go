@@ -2,7 +2,6 @@ package history
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"strings"
@@ -19,15 +18,62 @@ const KeyCommands = "history.commands"
 
 // StorePodProxy appends the given run configuration to history commands
 // in a form of non-interactive goproxie arguments.
-func StorePodProxy(projectID string, cluster *gcloud.Cluster, namespace string, pod *kubectl.Pod, localPort int, remotePort int) {
+func StorePodProxy(projectID string, cluster *gcloud.Cluster, namespace string, pod *kubectl.Pod, localPort int, remotePort int, bindAddress string) {
+	store.Append(KeyCommands, podProxyRecord(projectID, cluster, namespace, pod, localPort, bindAddress))
+}
+
+// podProxyRecord prefers the label selector the pod was picked by,
+// it keeps matching the pods after a rollout unlike the app label alone.
+func podProxyRecord(projectID string, cluster *gcloud.Cluster, namespace string, pod *kubectl.Pod, localPort int, bindAddress string) string {
+	podArg := fmt.Sprintf("-pod=%v", pod.AppLabel)
+	if pod.Selector != "" {
+		podArg = fmt.Sprintf("-selector=%v", pod.Selector)
+	}
+	return fmt.Sprintf("-project=%v -cluster=%v -namespace=%v %v -local_port=%v -bind_address=%v -proxy_type=pod", projectID, cluster.Name, namespace, podArg, localPort, bindAddress)
+}
 
-	record := fmt.Sprintf("-project=%v -cluster=%v -namespace=%v -pod=%v -local_port=%v -proxy_type=pod", projectID, cluster.Name, namespace, pod.AppLabel, localPort)
+// StoreTargetProxy appends the given K8S workload run configuration to history commands.
+// Workload names are stable across rollouts, unlike pod names.
+func StoreTargetProxy(projectID string, cluster *gcloud.Cluster, namespace string, target *kubectl.Target, localPort int, remotePort int, bindAddress string) {
+	record := fmt.Sprintf("-project=%v -cluster=%v -namespace=%v -target=%v -local_port=%v -remote_port=%v -bind_address=%v -proxy_type=%v", projectID, cluster.Name, namespace, target.Name, localPort, remotePort, bindAddress, target.Kind)
 	store.Append(KeyCommands, record)
 }
 
 // StoreCloudSQLProxy appends the given run configuration to history commands
-func StoreCloudSQLProxy(projectID string, instance sqlproxy.CloudSQLInstance, localPort int) {
-	record := fmt.Sprintf("-project=%v -sql_instance=%v -local_port=%v -proxy_type=sql", projectID, instance.ConnectionName, localPort)
+func StoreCloudSQLProxy(projectID string, listeners []sqlproxy.Listener, bindAddress string, options sqlproxy.ProxyOptions) {
+	record := fmt.Sprintf("-project=%v", projectID)
+	hasPort := false
+	for _, listener := range listeners {
+		hasPort = hasPort || listener.LocalPort != 0
+	}
+	if len(listeners) == 1 {
+		record += fmt.Sprintf(" -sql_instance=%v", listeners[0].Instance.ConnectionName)
+		// Port is not set when listening on Unix socket only
+		if hasPort {
+			record += fmt.Sprintf(" -local_port=%v", listeners[0].LocalPort)
+		}
+	} else {
+		record += fmt.Sprintf(" -sql_instance=%v", sqlproxy.FormatListeners(listeners))
+	}
+	if hasPort {
+		record += fmt.Sprintf(" -bind_address=%v", bindAddress)
+	}
+	if options.SocketDir != "" {
+		record += fmt.Sprintf(" -sql_socket_dir=%v", options.SocketDir)
+	}
+	if options.IAMAuth {
+		record += " -sql_iam_auth"
+	}
+	if len(options.IPTypes) > 0 {
+		record += fmt.Sprintf(" -sql_ip_type=%v", sqlproxy.FormatIPTypes(options.IPTypes))
+	}
+	if options.MaxConnections != 0 {
+		record += fmt.Sprintf(" -sql_max_connections=%v", options.MaxConnections)
+	}
+	if options.TermTimeout != 0 {
+		record += fmt.Sprintf(" -sql_term_timeout=%v", options.TermTimeout)
+	}
+	record += " -proxy_type=sql"
 	store.Append(KeyCommands, record)
 }
 
@@ -46,8 +92,8 @@ func deduplicate(commands []string) []string {
 }
 
 // Browse lets user choose from stored commands.
-// Goproxie is executed with given arguments.
-func Browse() {
+// Goproxie is executed with given arguments, its error is returned.
+func Browse() error {
 	storedCommands := store.Get(KeyCommands)
 	commands := []string{}
 	if storedCommands != nil {
@@ -61,7 +107,7 @@ func Browse() {
 
 	if len(commands) == 0 {
 		fmt.Println("History is empty")
-		os.Exit(0)
+		return nil
 	}
 
 	pickedCommand := ""
@@ -74,8 +120,5 @@ func Browse() {
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
-	err := cmd.Run()
-	if err != nil {
-		log.Fatal(err)
-	}
+	return cmd.Run()
 }
@@ -1 +1,36 @@
 package history
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AckeeCZ/goproxie/internal/gcloud"
+	"github.com/AckeeCZ/goproxie/internal/kubectl"
+)
+
+func TestPodProxyRecord(t *testing.T) {
+	cluster := &gcloud.Cluster{Name: "cluster-1"}
+	items := []struct {
+		pod      *kubectl.Pod
+		expected string
+	}{
+		{&kubectl.Pod{Name: "api-1", AppLabel: "api"}, "-project=project-1 -cluster=cluster-1 -namespace=ns -pod=api -local_port=1234 -bind_address=127.0.0.1 -proxy_type=pod"},
+		{&kubectl.Pod{Name: "api-1", AppLabel: "api", Selector: "app=api,component=worker"}, "-project=project-1 -cluster=cluster-1 -namespace=ns -selector=app=api,component=worker -local_port=1234 -bind_address=127.0.0.1 -proxy_type=pod"},
+	}
+	for _, item := range items {
+		result := podProxyRecord("project-1", cluster, "ns", item.pod, 1234, "127.0.0.1")
+		if result != item.expected {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+}
+
+func TestPodProxyRecordReplay(t *testing.T) {
+	pod := &kubectl.Pod{Name: "api-1", AppLabel: "api", Selector: "app=api,component=worker"}
+	record := podProxyRecord("project-1", &gcloud.Cluster{Name: "cluster-1"}, "ns", pod, 1234, "127.0.0.1")
+	// Browse splits the record to arguments on whitespace
+	fields := strings.Fields(record)
+	if fields[3] != "-selector=app=api,component=worker" {
+		t.Errorf("Expected `%v` does not match result `%v`", "-selector=app=api,component=worker", fields[3])
+	}
+}
@@ -1,18 +1,43 @@
 package kubectl
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/AckeeCZ/goproxie/internal/errs"
+	"github.com/AckeeCZ/goproxie/internal/health"
+	"github.com/AckeeCZ/goproxie/internal/metrics"
 	"github.com/AckeeCZ/goproxie/internal/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var kubectlPath = "kubectl"
 
+// Address local ports are bound to
+var bindAddress = "127.0.0.1"
+
+// SetBindAddress sets the address local ports of port-forwards are bound to.
+func SetBindAddress(address string) {
+	bindAddress = address
+}
+
+// Kubeconfig file used instead of the default one, if set
+var kubeconfigPath = ""
+
 var runCommand = util.RunCommand
 
 // SetKubectlPath sets the executable path to kubectl bin.
@@ -20,58 +45,329 @@ func SetKubectlPath(path string) {
 	kubectlPath = path
 }
 
+// SetKubeconfig sets the kubeconfig file used by all kubectl calls and the native forwarder.
+// Empty path means the default kubeconfig (`$KUBECONFIG` or `~/.kube/config`).
+func SetKubeconfig(path string) {
+	kubeconfigPath = path
+}
+
+// withKubeconfig appends `--kubeconfig` to kubectl args when set
+func withKubeconfig(args ...string) []string {
+	if kubeconfigPath == "" {
+		return args
+	}
+	return append(args, "--kubeconfig", kubeconfigPath)
+}
+
 // Pod structure
 type Pod struct {
 	Name           string
 	Containers     []string
 	ContainerPorts []int
-	AppLabel       string
+	// Ports are the container ports with their names, ContainerPorts lists just the numbers
+	Ports    []ContainerPort
+	AppLabel string
+	Images   []string
+	Labels   map[string]string
+	// Phase is one of Pending, Running, Succeeded, Failed, Unknown
+	Phase string
+	// Status is the reason shown by kubectl, e.g. Running, CrashLoopBackOff, Completed, Terminating
+	Status string
+	// Ready is true when the pod is running and passes its readiness probes
+	Ready           bool
+	ReadyContainers int
+	// Restarts is the sum of restarts of all containers
+	Restarts    int
+	Node        string
+	StartTime   time.Time
+	Terminating bool
+	// Selector is the label selector the pod was picked by, its replacement is resolved by it on reconnect.
+	// App label is used when empty.
+	Selector string
+}
+
+// ContainerPort is a port exposed by a container of the pod
+type ContainerPort struct {
+	Container string
+	// Name is the optional port name, e.g. `http`, services may refer to it
+	Name     string
+	Port     int
+	Protocol string
 }
 
 // NamespacesList returns the list of k8s namespaces
-func NamespacesList() []string {
-	return strings.Fields(runCommand(kubectlPath, "get", "namespaces", "-o=custom-columns=NAME:.metadata.name", "--no-headers"))
+func NamespacesList(ctx context.Context) ([]string, error) {
+	out, err := runCommand(ctx, kubectlPath, withKubeconfig("get", "namespaces", "-o=custom-columns=NAME:.metadata.name", "--no-headers")...)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// PodsList returns the list of k8s pods from the given namespace matching the label selector, all when empty
+func PodsList(ctx context.Context, namespace string, selector string) ([]*Pod, error) {
+	extra := []string{}
+	if selector != "" {
+		extra = append(extra, "--selector", selector)
+	}
+	out, err := runCommand(ctx, kubectlPath, podsListArgs(namespace, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parsePodsList(out)
+}
+
+// podsListArgs returns arguments of kubectl pods listing, extra args are appended
+func podsListArgs(namespace string, extra ...string) []string {
+	args := []string{"get", "pods", "--namespace", namespace, "-o", "json"}
+	return withKubeconfig(append(args, extra...)...)
 }
 
-// PodsList returns the list of k8s pods from the given namespace
-func PodsList(namespace string) []*Pod {
-	out := runCommand(kubectlPath, "get", "pods", "--namespace", namespace, "--no-headers",
-		"-o=custom-columns=NAME:.metadata.name,CONTAINERS:spec.containers[*].name,PORTS:.spec.containers[*].ports[*].containerPort,LABELS=:.metadata.labels.app")
-	lines := strings.Split(out, "\n")
+// parsePodsList decodes `kubectl get pods -o json` output
+func parsePodsList(out string) ([]*Pod, error) {
+	list := corev1.PodList{}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, fmt.Errorf("couldn't parse kubectl pods list: %v", err)
+	}
 	pods := []*Pod{}
-	for _, line := range lines {
-		tokens := strings.Fields(line)
-		if len(tokens) < 3 {
-			continue
+	for i := range list.Items {
+		pods = append(pods, newPod(&list.Items[i]))
+	}
+	return pods, nil
+}
+
+func newPod(item *corev1.Pod) *Pod {
+	pod := &Pod{
+		Name:           item.Name,
+		Containers:     []string{},
+		ContainerPorts: []int{},
+		Ports:          []ContainerPort{},
+		AppLabel:       item.Labels["app"],
+		Images:         []string{},
+		Labels:         item.Labels,
+		Phase:          string(item.Status.Phase),
+		Ready:          isPodReady(item),
+		Node:           item.Spec.NodeName,
+		Terminating:    item.DeletionTimestamp != nil,
+	}
+	// App label defaults to the pod name, so the same pod is reconnected
+	if pod.AppLabel == "" {
+		pod.AppLabel = pod.Name
+	}
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	if item.Status.StartTime != nil {
+		pod.StartTime = item.Status.StartTime.Time
+	}
+	for _, container := range item.Spec.Containers {
+		pod.Containers = append(pod.Containers, container.Name)
+		pod.Images = append(pod.Images, container.Image)
+		for _, port := range container.Ports {
+			pod.ContainerPorts = append(pod.ContainerPorts, int(port.ContainerPort))
+			pod.Ports = append(pod.Ports, ContainerPort{Container: container.Name, Name: port.Name, Port: int(port.ContainerPort), Protocol: string(port.Protocol)})
 		}
-		containers := strings.Split(tokens[1], ",")
-		portsStr := strings.Split(tokens[2], ",")
-		ports := make([]int, 0, len(portsStr))
-		for _, portStr := range portsStr {
-			port, err := strconv.Atoi(portStr)
-			if (err == nil) {
-				ports = append(ports, port)
-			}
+	}
+	pod.Status = pod.Phase
+	if item.Status.Reason != "" {
+		pod.Status = item.Status.Reason
+	}
+	for _, status := range item.Status.ContainerStatuses {
+		pod.Restarts += int(status.RestartCount)
+		if status.Ready {
+			pod.ReadyContainers++
 		}
-		name := tokens[0]
-		appLabel := tokens[3]
-		// `<none>` is empty serialization value from kubectl
-		if appLabel == "<none>" {
-			appLabel = name
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" {
+			pod.Status = status.State.Waiting.Reason
+		} else if status.State.Terminated != nil && status.State.Terminated.Reason != "" {
+			pod.Status = status.State.Terminated.Reason
 		}
-		pods = append(pods, &Pod{Name: name, Containers: containers, ContainerPorts: ports, AppLabel: appLabel})
 	}
-	return pods
+	if pod.Terminating {
+		pod.Status = "Terminating"
+	}
+	return pod
 }
 
-// PortForward executes kubectl's 'port-forward'.
-// Local port is bound to 0.0.0.0. via '--address'.
-func PortForward(podID string, localPort int, remotePort int, namespace string) {
-	cmd := exec.Command(kubectlPath, "port-forward", podID, fmt.Sprintf("%v:%v", localPort, remotePort), "--namespace", namespace, "--address", "0.0.0.0")
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err := cmd.Run()
+// Reconnection backoff bounds of PortForward
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// How long pod lookups on reconnect may take
+const resolveTimeout = 30 * time.Second
+
+// kubectl port-forward output markers
+const (
+	forwardingMarker     = "Forwarding from"
+	lostConnectionMarker = "lost connection to pod"
+)
+
+// PortForward forwards local port to the pod and keeps it running until ctx is done.
+// When the forward ends or loses connection to the pod (e.g. the pod was replaced by a rollout),
+// a running pod with the same app label is resolved and the forward is re-established
+// on the same local port with exponential backoff.
+// Uses the native forwarder or kubectl's 'port-forward', see SetForwarder.
+// Local port is bound to the address set by SetBindAddress.
+// Returns nil when ctx is done, error when the forward never became ready.
+func PortForward(ctx context.Context, pod *Pod, localPort int, remotePort int, namespace string) error {
+	if forwarder == ForwarderNative {
+		return nativePortForward(ctx, podResolverFor(pod, remotePort, namespace), localPort, namespace)
+	}
+	return supervisePortForward(ctx, pod.Name, func(last string) string { return resolvePod(ctx, pod, last, namespace) }, localPort, remotePort, namespace)
+}
+
+// supervisePortForward keeps kubectl port-forward to the resource running.
+// Resolve returns the resource to reconnect to given the last one used.
+func supervisePortForward(ctx context.Context, resource string, resolve func(last string) string, localPort int, remotePort int, namespace string) error {
+	delay := minReconnectDelay
+	wasReady := false
+	for attempt := 1; ; attempt++ {
+		ready, err := runPortForward(ctx, resource, localPort, remotePort, namespace)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if ready {
+			wasReady = true
+			delay = minReconnectDelay
+			attempt = 1
+		}
+		metrics.Inc(metrics.Errors, "type", "forward")
+		// Forward that never worked is a configuration problem (e.g. port in use), not a rollout
+		if !wasReady {
+			return err
+		}
+		log.Printf("Port-forward to %v ended (%v). Reconnecting in %v (attempt %v)", resource, err, delay, attempt)
+		metrics.Inc(metrics.Reconnects, "type", "pod")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+		resource = resolve(resource)
+	}
+}
+
+// ValidateSelector checks the label selector given by user, empty one is valid.
+// Whitespace is not allowed, history commands are split on it.
+func ValidateSelector(selector string) error {
+	if strings.ContainsAny(selector, " \t\n") {
+		return fmt.Errorf("label selector %q must not contain whitespace, e.g. app=api,component=worker", selector)
+	}
+	_, err := labels.Parse(selector)
+	return err
+}
+
+// podSelector returns label selector of the pod's replacements, empty when the pod has no app label
+func podSelector(pod *Pod) string {
+	if pod.Selector != "" {
+		return pod.Selector
+	}
+	if pod.AppLabel == pod.Name {
+		return ""
+	}
+	return "app=" + pod.AppLabel
+}
+
+// resolvePod finds a ready pod matching the selector or with the same app label as the original pod.
+// Pods other than the last used one are preferred, it may be terminating.
+// Last used pod name is returned when no pod can be found.
+func resolvePod(ctx context.Context, pod *Pod, lastPodName string, namespace string) string {
+	selector := podSelector(pod)
+	// App label defaults to pod name when the label is not set, nothing to search by
+	if selector == "" {
+		return lastPodName
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	var candidates []*Pod
+	out, err := runCommand(ctx, kubectlPath, podsListArgs(namespace, "--selector", selector, "--field-selector", "status.phase=Running")...)
+	if err == nil {
+		candidates, err = parsePodsList(out)
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Could not list pods with %v: %v", selector, err)
+		return lastPodName
+	}
+	for _, candidate := range candidates {
+		if candidate.Ready && candidate.Name != lastPodName {
+			log.Printf("Resolved pod %v with %v", candidate.Name, selector)
+			return candidate.Name
+		}
+	}
+	return lastPodName
+}
+
+// runPortForward runs a single kubectl port-forward until it exits or ctx is done.
+// Reports whether the forward was ready (kubectl printed it's forwarding).
+func runPortForward(ctx context.Context, resource string, localPort int, remotePort int, namespace string) (ready bool, err error) {
+	cmd := exec.Command(kubectlPath, withKubeconfig("port-forward", resource, fmt.Sprintf("%v:%v", localPort, remotePort), "--namespace", namespace, "--address", bindAddress)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return false, err
+	}
+	op := "kubectl port-forward " + resource
+	if err := cmd.Start(); err != nil {
+		return false, errs.Classify(op, "", err)
+	}
+	var readyFlag, lostFlag int32
+	listenerAddress := net.JoinHostPort(bindAddress, strconv.Itoa(localPort))
+	listenerLabels := []string{"type", "pod", "listener", listenerAddress}
+	defer metrics.Set(metrics.TunnelsActive, 0, listenerLabels...)
+	health.Set(health.Tunnel{Type: "pod", Listener: listenerAddress, Target: resource, Detail: "connecting"})
+	defer health.Update(listenerAddress, false, "reconnecting")
+	var wg sync.WaitGroup
+	// Read once kubectl exits, attached to the error
+	captured := &strings.Builder{}
+	scan := func(r io.Reader, w io.Writer) {
+		defer wg.Done()
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			fmt.Fprintln(w, line)
+			if w == os.Stderr {
+				fmt.Fprintln(captured, line)
+			}
+			if strings.Contains(line, forwardingMarker) && atomic.CompareAndSwapInt32(&readyFlag, 0, 1) {
+				metrics.Set(metrics.TunnelsActive, 1, listenerLabels...)
+				health.Update(listenerAddress, true, "")
+			}
+			// kubectl may stay alive with a dead pod, force the restart
+			if strings.Contains(line, lostConnectionMarker) && atomic.CompareAndSwapInt32(&lostFlag, 0, 1) {
+				cmd.Process.Kill()
+			}
+		}
+	}
+	wg.Add(2)
+	go scan(stdout, os.Stdout)
+	go scan(stderr, os.Stderr)
+	done := make(chan error, 1)
+	go func() {
+		wg.Wait()
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return atomic.LoadInt32(&readyFlag) == 1, ctx.Err()
+	case err = <-done:
+	}
+	if atomic.LoadInt32(&lostFlag) == 1 {
+		err = errors.New(lostConnectionMarker)
+	}
+	if err == nil {
+		err = errors.New("kubectl exited")
 	}
+	return atomic.LoadInt32(&readyFlag) == 1, errs.Classify(op, captured.String(), err)
 }
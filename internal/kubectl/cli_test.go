@@ -1,15 +1,74 @@
 package kubectl
 
-import "testing"
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 // Exact command results
 
-// Should contain <none> ports and multi-ports
-var mockPodsList = `acme-rockets-v0.3.0-74bf544f8b-lzc5b                      event-exporter,prometheus-to-sd-exporter      <none>           acme-rockets
-acme-finances-0                                           event-exporter                                <none>           <none>
-metrics-server-v0.3.3-6d96fcc55-2qtm8                       metrics-server,metrics-server-nanny           443           metrics-server
-traefik-ig-7646cb565d-9zxv6                                 traefik                                       80,443,8080,8081           traefik-ig
-`
+// Trimmed `kubectl get pods -o json` output with odd pods: no labels, no ports, no status, terminating
+var mockPodsList = `{
+	"apiVersion": "v1",
+	"items": [
+		{
+			"metadata": {"name": "acme-rockets-74bf544f8b-lzc5b", "labels": {"app": "acme-rockets", "component": "api"}},
+			"spec": {
+				"nodeName": "gke-pool-1-abcd",
+				"containers": [
+					{"name": "rockets", "image": "acme/rockets:1.2", "ports": [{"name": "http", "containerPort": 3000, "protocol": "TCP"}, {"containerPort": 9090, "protocol": "TCP"}]},
+					{"name": "prometheus-to-sd-exporter", "image": "prometheus-to-sd:0.9"}
+				]
+			},
+			"status": {
+				"phase": "Running",
+				"startTime": "2021-03-17T10:00:00Z",
+				"conditions": [{"type": "Ready", "status": "True"}],
+				"containerStatuses": [{"name": "rockets", "ready": true, "restartCount": 2}, {"name": "prometheus-to-sd-exporter", "ready": true, "restartCount": 1}]
+			}
+		},
+		{
+			"metadata": {"name": "acme-migrate-x7k2p"},
+			"spec": {"containers": [{"name": "migrate", "image": "acme/migrate"}]},
+			"status": {
+				"phase": "Succeeded",
+				"startTime": "2021-03-17T09:00:00Z",
+				"conditions": [{"type": "Ready", "status": "False", "reason": "PodCompleted"}],
+				"containerStatuses": [{"name": "migrate", "ready": false, "restartCount": 0, "state": {"terminated": {"exitCode": 0, "reason": "Completed"}}}]
+			}
+		},
+		{
+			"metadata": {"name": "acme-finances-0", "labels": {"app": "acme-finances"}},
+			"spec": {"containers": [{"name": "finances", "image": "acme/finances", "ports": [{"containerPort": 8080}]}]},
+			"status": {"phase": "Pending"}
+		},
+		{
+			"metadata": {"name": "acme-worker-5d8f7c-qwert", "labels": {"app": "acme-worker"}},
+			"spec": {"containers": [{"name": "worker", "image": "acme/worker"}]},
+			"status": {
+				"phase": "Running",
+				"startTime": "2021-03-17T08:00:00Z",
+				"conditions": [{"type": "Ready", "status": "False", "reason": "ContainersNotReady"}],
+				"containerStatuses": [{"name": "worker", "ready": false, "restartCount": 12, "state": {"waiting": {"reason": "CrashLoopBackOff"}}}]
+			}
+		},
+		{
+			"metadata": {"name": "acme-rockets-74bf544f8b-old", "labels": {"app": "acme-rockets"}, "deletionTimestamp": "2021-03-17T10:05:00Z"},
+			"spec": {"nodeName": "gke-pool-1-efgh", "containers": [{"name": "rockets", "image": "acme/rockets:1.1", "ports": [{"name": "http", "containerPort": 3000}]}]},
+			"status": {
+				"phase": "Running",
+				"startTime": "2021-03-16T10:00:00Z",
+				"conditions": [{"type": "Ready", "status": "True"}],
+				"containerStatuses": [{"name": "rockets", "ready": true, "restartCount": 0}]
+			}
+		}
+	],
+	"kind": "List"
+}`
+
 var mockNamespacesList = `acme-sro-development
 default
 infrastructure-development
@@ -17,8 +76,8 @@ infrastructure-development
 
 func mockRunCommand(mockResponse string) func() {
 	originalRunCommand := runCommand
-	runCommand = func(cmd string, args ...string) string {
-		return mockResponse
+	runCommand = func(_ context.Context, cmd string, args ...string) (string, error) {
+		return mockResponse, nil
 	}
 	return func() {
 		runCommand = originalRunCommand
@@ -28,7 +87,10 @@ func mockRunCommand(mockResponse string) func() {
 func TestNamespacesList(t *testing.T) {
 	unmock := mockRunCommand(mockNamespacesList)
 	defer unmock()
-	result := NamespacesList()
+	result, err := NamespacesList(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	expectedItems := []string{
 		"acme-sro-development",
 		"default",
@@ -47,71 +109,171 @@ func TestNamespacesList(t *testing.T) {
 func TestPodsList(t *testing.T) {
 	unmock := mockRunCommand(mockPodsList)
 	defer unmock()
-	result := PodsList("anynamespace")
+	result, err := PodsList(context.Background(), "anynamespace", "")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
 	expectedItems := []*Pod{
 		{
-			Name:           "acme-rockets-v0.3.0-74bf544f8b-lzc5b",
-			ContainerPorts: []int{},
-			Containers: []string{
-				"event-exporter",
-				"prometheus-to-sd-exporter",
+			Name:           "acme-rockets-74bf544f8b-lzc5b",
+			Containers:     []string{"rockets", "prometheus-to-sd-exporter"},
+			ContainerPorts: []int{3000, 9090},
+			Ports: []ContainerPort{
+				{Container: "rockets", Name: "http", Port: 3000, Protocol: "TCP"},
+				{Container: "rockets", Port: 9090, Protocol: "TCP"},
 			},
-			AppLabel: "acme-rockets",
+			AppLabel:        "acme-rockets",
+			Images:          []string{"acme/rockets:1.2", "prometheus-to-sd:0.9"},
+			Labels:          map[string]string{"app": "acme-rockets", "component": "api"},
+			Phase:           "Running",
+			Status:          "Running",
+			Ready:           true,
+			ReadyContainers: 2,
+			Restarts:        3,
+			Node:            "gke-pool-1-abcd",
+			StartTime:       time.Date(2021, 3, 17, 10, 0, 0, 0, time.UTC),
 		},
 		{
-			Name:           "acme-finances-0",
+			Name:           "acme-migrate-x7k2p",
+			Containers:     []string{"migrate"},
 			ContainerPorts: []int{},
-			Containers: []string{
-				"event-exporter",
-			},
-			AppLabel: "acme-finances-0",
+			Ports:          []ContainerPort{},
+			AppLabel:       "acme-migrate-x7k2p",
+			Images:         []string{"acme/migrate"},
+			Labels:         map[string]string{},
+			Phase:          "Succeeded",
+			Status:         "Completed",
+			StartTime:      time.Date(2021, 3, 17, 9, 0, 0, 0, time.UTC),
 		},
 		{
-			Name:           "metrics-server-v0.3.3-6d96fcc55-2qtm8",
-			ContainerPorts: []int{443},
-			Containers: []string{
-				"metrics-server",
-				"metrics-server-nanny",
-			},
-			AppLabel: "metrics-server",
+			Name:           "acme-finances-0",
+			Containers:     []string{"finances"},
+			ContainerPorts: []int{8080},
+			Ports:          []ContainerPort{{Container: "finances", Port: 8080}},
+			AppLabel:       "acme-finances",
+			Images:         []string{"acme/finances"},
+			Labels:         map[string]string{"app": "acme-finances"},
+			Phase:          "Pending",
+			Status:         "Pending",
 		},
 		{
-			Name:           "traefik-ig-7646cb565d-9zxv6",
-			ContainerPorts: []int{80, 443, 8080, 8081},
-			Containers: []string{
-				"traefik",
-			},
-			AppLabel: "traefik-ig",
+			Name:           "acme-worker-5d8f7c-qwert",
+			Containers:     []string{"worker"},
+			ContainerPorts: []int{},
+			Ports:          []ContainerPort{},
+			AppLabel:       "acme-worker",
+			Images:         []string{"acme/worker"},
+			Labels:         map[string]string{"app": "acme-worker"},
+			Phase:          "Running",
+			Status:         "CrashLoopBackOff",
+			Restarts:       12,
+			StartTime:      time.Date(2021, 3, 17, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:            "acme-rockets-74bf544f8b-old",
+			Containers:      []string{"rockets"},
+			ContainerPorts:  []int{3000},
+			Ports:           []ContainerPort{{Container: "rockets", Name: "http", Port: 3000}},
+			AppLabel:        "acme-rockets",
+			Images:          []string{"acme/rockets:1.1"},
+			Labels:          map[string]string{"app": "acme-rockets"},
+			Phase:           "Running",
+			Status:          "Terminating",
+			ReadyContainers: 1,
+			Node:            "gke-pool-1-efgh",
+			StartTime:       time.Date(2021, 3, 16, 10, 0, 0, 0, time.UTC),
+			Terminating:     true,
 		},
 	}
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	}
 	for i, expectedItem := range expectedItems {
-		resultItem := result[i]
-		if expectedItem.Name != resultItem.Name {
-			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, resultItem)
-		}
-		if expectedItem.AppLabel != resultItem.AppLabel {
-			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, resultItem)
+		if !expectedItem.StartTime.Equal(result[i].StartTime) {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem.StartTime, result[i].StartTime)
 		}
-		for i, expectedPort := range expectedItem.ContainerPorts {
-			resultPort := resultItem.ContainerPorts[i]
-			if expectedPort != resultPort {
-				t.Errorf("Expected `%v` does not match result `%v`", expectedPort, resultPort)
-			}
+		// Decoded in local time zone
+		result[i].StartTime = expectedItem.StartTime
+		if !reflect.DeepEqual(expectedItem, result[i]) {
+			t.Errorf("Expected `%+v` does not match result `%+v`", expectedItem, result[i])
 		}
-		if len(expectedItem.ContainerPorts) != len(resultItem.ContainerPorts) {
-			t.Errorf("Expected len `%v` does not match result `%v`", expectedItem.ContainerPorts, resultItem.ContainerPorts)
+	}
+}
+
+func TestPodsListOddOutput(t *testing.T) {
+	items := []struct {
+		out      string
+		expected int
+		err      bool
+	}{
+		{`{"apiVersion": "v1", "items": [], "kind": "List"}`, 0, false},
+		{`{"items": [{"metadata": {"name": "bare"}}]}`, 1, false},
+		{`No resources found in default namespace.`, 0, true},
+		{``, 0, true},
+	}
+	for _, item := range items {
+		unmock := mockRunCommand(item.out)
+		result, err := PodsList(context.Background(), "anynamespace", "")
+		unmock()
+		if (err != nil) != item.err {
+			t.Errorf("Expected error `%v` does not match result `%v` for %q", item.err, err, item.out)
 		}
-		for i, expectedContainer := range expectedItem.Containers {
-			resultContainer := resultItem.Containers[i]
-			if expectedContainer != resultContainer {
-				t.Errorf("Expected `%v` does not match result `%v`", expectedContainer, resultContainer)
-			}
+		if len(result) != item.expected {
+			t.Errorf("Expected len `%v` does not match result `%v` for %q", item.expected, len(result), item.out)
 		}
-		if len(expectedItem.Containers) != len(resultItem.Containers) {
-			t.Errorf("Expected len `%v` does not match result `%v`", len(expectedItem.Containers), len(resultItem.Containers))
+	}
+}
+
+func TestPodsListSelector(t *testing.T) {
+	originalRunCommand := runCommand
+	calledWith := []string{}
+	runCommand = func(_ context.Context, cmd string, args ...string) (string, error) {
+		calledWith = args
+		return `{"items": []}`, nil
+	}
+	defer func() { runCommand = originalRunCommand }()
+	if _, err := PodsList(context.Background(), "anynamespace", "app=api,component=worker"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := "get pods --namespace anynamespace -o json --selector app=api,component=worker"
+	if strings.Join(calledWith, " ") != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, strings.Join(calledWith, " "))
+	}
+}
+
+func TestResolvePod(t *testing.T) {
+	unmock := mockRunCommand(`{"items": [
+		{"metadata": {"name": "acme-rockets-74bf544f8b-lzc5b", "labels": {"app": "acme-rockets"}}, "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "acme-rockets-74bf544f8b-starting", "labels": {"app": "acme-rockets"}}, "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "False"}]}},
+		{"metadata": {"name": "acme-rockets-74bf544f8b-x9k2p", "labels": {"app": "acme-rockets"}}, "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}}
+	]}`)
+	defer unmock()
+	pod := &Pod{Name: "acme-rockets-74bf544f8b-lzc5b", AppLabel: "acme-rockets"}
+	result := resolvePod(context.Background(), pod, "acme-rockets-74bf544f8b-lzc5b", "anynamespace")
+	if result != "acme-rockets-74bf544f8b-x9k2p" {
+		t.Errorf("Expected `%v` does not match result `%v`", "acme-rockets-74bf544f8b-x9k2p", result)
+	}
+}
+
+func TestResolvePodWithoutLabel(t *testing.T) {
+	unmock := mockRunCommand(`{"items": [{"metadata": {"name": "other-pod", "labels": {"app": "other"}}, "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}]}}]}`)
+	defer unmock()
+	pod := &Pod{Name: "acme-finances-0", AppLabel: "acme-finances-0"}
+	result := resolvePod(context.Background(), pod, "acme-finances-0", "anynamespace")
+	if result != "acme-finances-0" {
+		t.Errorf("Expected `%v` does not match result `%v`", "acme-finances-0", result)
+	}
+}
+
+func TestValidateSelector(t *testing.T) {
+	for _, selector := range []string{"", "app=api,component=worker", "app!=api,canary"} {
+		if err := ValidateSelector(selector); err != nil {
+			t.Errorf("Unexpected error %v for `%v`", err, selector)
 		}
 	}
-	if len(expectedItems) != len(result) {
-		t.Errorf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	for _, selector := range []string{"app=api, component=worker", "env in (prod,staging)", "=api"} {
+		if err := ValidateSelector(selector); err == nil {
+			t.Errorf("Expected error for `%v`", selector)
+		}
 	}
 }
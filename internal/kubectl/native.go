@@ -0,0 +1,480 @@
+package kubectl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
+	"github.com/AckeeCZ/goproxie/internal/health"
+	"github.com/AckeeCZ/goproxie/internal/metrics"
+	"github.com/AckeeCZ/goproxie/internal/stats"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	// GKE kubeconfigs authenticate via gcloud auth provider
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// Port-forward implementations
+const (
+	// ForwarderNative forwards in-process via the Kubernetes API using the kubeconfig
+	ForwarderNative = "native"
+	// ForwarderKubectl executes `kubectl port-forward`
+	ForwarderKubectl = "kubectl"
+)
+
+var forwarder = ForwarderNative
+
+// SetForwarder sets the port-forward implementation, one of ForwarderNative, ForwarderKubectl.
+func SetForwarder(name string) error {
+	if name != ForwarderNative && name != ForwarderKubectl {
+		return fmt.Errorf("unknown forwarder %q, use %v or %v", name, ForwarderNative, ForwarderKubectl)
+	}
+	forwarder = name
+	return nil
+}
+
+// How often the forwarded pod is checked to be still alive
+const podCheckInterval = 10 * time.Second
+
+// How long local connections wait for the forward to be (re)established
+const connectionWaitTimeout = 30 * time.Second
+
+// podResolver returns the pod and its port to forward to, given the last pod used
+type podResolver func(ctx context.Context, clientset kubernetes.Interface, lastPod string) (podName string, remotePort int, err error)
+
+// nativeForwarder keeps a local listener open and forwards its connections
+// over a SPDY connection to the API server, which is re-established when the pod goes away.
+type nativeForwarder struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+	namespace string
+	localPort int
+
+	mutex      sync.Mutex
+	conn       httpstream.Connection
+	podName    string
+	remotePort int
+	// closed when conn is available, replaced when reconnecting
+	connected chan struct{}
+	// signals the current connection is broken and should be re-established
+	broken    chan struct{}
+	requestID int32
+}
+
+func newClient() (*rest.Config, kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfigPath
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, clientset, nil
+}
+
+// nativePortForward forwards local port to the resolved pod until ctx is done.
+// Prints the same `Forwarding from` line as kubectl when ready.
+// Returns nil when ctx is done, error when the forward never became ready.
+func nativePortForward(ctx context.Context, resolve podResolver, localPort int, namespace string) error {
+	config, clientset, err := newClient()
+	if err != nil {
+		return errs.Classify("kubeconfig", "", err)
+	}
+	f := &nativeForwarder{
+		config:    config,
+		clientset: clientset,
+		namespace: namespace,
+		localPort: localPort,
+		connected: make(chan struct{}),
+		broken:    make(chan struct{}, 1),
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(localPort)))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	go f.accept(listener)
+	health.Set(health.Tunnel{Type: "pod", Listener: listener.Addr().String(), Detail: "connecting"})
+	defer health.Remove(listener.Addr().String())
+
+	delay := minReconnectDelay
+	wasReady := false
+	lastPod := ""
+	for attempt := 1; ; attempt++ {
+		podName, remotePort, err := resolve(ctx, clientset, lastPod)
+		if err != nil {
+			metrics.Inc(metrics.Errors, "type", "resolve")
+		}
+		var conn httpstream.Connection
+		if err == nil {
+			conn, err = f.dial(podName)
+			if err != nil {
+				metrics.Inc(metrics.Errors, "type", "dial")
+			}
+		}
+		if err == nil {
+			lastPod = podName
+			wasReady = true
+			delay = minReconnectDelay
+			attempt = 1
+			f.setConnection(conn, podName, remotePort)
+			fmt.Printf("Forwarding from %v -> %v\n", listener.Addr(), remotePort)
+			metrics.Set(metrics.TunnelsActive, 1, "type", "pod", "listener", listener.Addr().String())
+			health.Set(health.Tunnel{Type: "pod", Listener: listener.Addr().String(), Target: podName, Ready: true})
+			err = f.watch(ctx, conn, podName)
+			metrics.Set(metrics.TunnelsActive, 0, "type", "pod", "listener", listener.Addr().String())
+			health.Update(listener.Addr().String(), false, "reconnecting")
+			f.clearConnection()
+			conn.Close()
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+		// Forward that never worked is a configuration problem, not a rollout
+		if !wasReady {
+			return errs.Classify("port-forward", "", err)
+		}
+		log.Printf("Port-forward to %v ended (%v). Reconnecting in %v (attempt %v)", lastPod, err, delay, attempt)
+		metrics.Inc(metrics.Reconnects, "type", "pod")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// dial opens SPDY port-forward connection to the pod
+func (f *nativeForwarder) dial(podName string) (httpstream.Connection, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(f.config)
+	if err != nil {
+		return nil, err
+	}
+	url := f.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(f.namespace).Name(podName).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	return conn, err
+}
+
+// watch blocks until the connection is lost, the pod goes away or ctx is done
+func (f *nativeForwarder) watch(ctx context.Context, conn httpstream.Connection, podName string) error {
+	ticker := time.NewTicker(podCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-conn.CloseChan():
+			return errors.New(lostConnectionMarker)
+		case <-f.broken:
+			return errors.New(lostConnectionMarker)
+		case <-ticker.C:
+			pod, err := f.getPod(ctx, podName)
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("pod %v was deleted", podName)
+			}
+			if err == nil && (pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning) {
+				return fmt.Errorf("pod %v is terminating", podName)
+			}
+		}
+	}
+}
+
+// getPod gets the forwarded pod to check it is still alive
+func (f *nativeForwarder) getPod(ctx context.Context, podName string) (*corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return f.clientset.CoreV1().Pods(f.namespace).Get(ctx, podName, metav1.GetOptions{})
+}
+
+func (f *nativeForwarder) setConnection(conn httpstream.Connection, podName string, remotePort int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.conn = conn
+	f.podName = podName
+	f.remotePort = remotePort
+	close(f.connected)
+}
+
+func (f *nativeForwarder) clearConnection() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.conn = nil
+	f.connected = make(chan struct{})
+}
+
+// connection waits for the forward to be established
+func (f *nativeForwarder) connection() (httpstream.Connection, string, int, error) {
+	f.mutex.Lock()
+	connected := f.connected
+	f.mutex.Unlock()
+	select {
+	case <-connected:
+	case <-time.After(connectionWaitTimeout):
+		return nil, "", 0, errors.New("timed out waiting for port-forward to reconnect")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.conn == nil {
+		return nil, "", 0, errors.New("port-forward is reconnecting")
+	}
+	return f.conn, f.podName, f.remotePort, nil
+}
+
+func (f *nativeForwarder) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed network connection") {
+				metrics.Inc(metrics.Errors, "type", "accept")
+				log.Printf("Error accepting connection on port %v: %v", f.localPort, err)
+			}
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+// handle copies data between the local connection and the pod, mirrors kubectl's implementation
+func (f *nativeForwarder) handle(local net.Conn) {
+	fmt.Printf("Handling connection for %v\n", f.localPort)
+	streamConn, podName, remotePort, err := f.connection()
+	if err != nil {
+		local.Close()
+		log.Printf("Error handling connection for %v: %v", f.localPort, err)
+		return
+	}
+	conn := stats.Track(local, fmt.Sprintf("%v:%v", podName, remotePort))
+	defer conn.Close()
+	requestID := atomic.AddInt32(&f.requestID, 1)
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(remotePort))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(int(requestID)))
+	errorStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		f.markBroken(fmt.Errorf("error creating error stream for port %v -> %v: %v", f.localPort, remotePort, err))
+		return
+	}
+	// we're not writing to this stream
+	errorStream.Close()
+	errorChan := make(chan error, 1)
+	go func() {
+		message, err := ioutil.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errorChan <- fmt.Errorf("error reading from error stream for port %v -> %v: %v", f.localPort, remotePort, err)
+		case len(message) > 0:
+			errorChan <- fmt.Errorf("an error occurred forwarding %v -> %v: %v", f.localPort, remotePort, string(message))
+		}
+		close(errorChan)
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		f.markBroken(fmt.Errorf("error creating forwarding stream for port %v -> %v: %v", f.localPort, remotePort, err))
+		return
+	}
+	localError := make(chan struct{})
+	remoteDone := make(chan struct{})
+	go func() {
+		// Copy from the remote side to the local port.
+		if _, err := io.Copy(conn, dataStream); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error copying from remote stream to local connection: %v", err)
+		}
+		close(remoteDone)
+	}()
+	go func() {
+		// inform server we're not sending any more data after copy unblocks
+		defer dataStream.Close()
+		// Copy from the local port to the remote side.
+		if _, err := io.Copy(dataStream, conn); err != nil && !strings.Contains(err.Error(), "use of closed network connection") {
+			log.Printf("Error copying from local connection to remote stream: %v", err)
+			close(localError)
+		}
+	}()
+	// wait for either a local->remote error or for copying from remote->local to finish
+	select {
+	case <-remoteDone:
+	case <-localError:
+	}
+	if err := <-errorChan; err != nil {
+		metrics.Inc(metrics.Errors, "type", "stream")
+		conn.SetReason(err.Error())
+		log.Print(err)
+	}
+}
+
+// markBroken requests the connection to be re-established
+func (f *nativeForwarder) markBroken(err error) {
+	metrics.Inc(metrics.Errors, "type", "stream")
+	log.Print(err)
+	select {
+	case f.broken <- struct{}{}:
+	default:
+	}
+}
+
+// isPodReady reports whether the pod is running and passes its readiness probes
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// pickPod picks a ready pod matching the selector, pods other than the last used one are preferred
+func pickPod(ctx context.Context, clientset kubernetes.Interface, namespace string, selector labels.Selector, lastPod string) (*corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	var picked *corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !isPodReady(pod) {
+			continue
+		}
+		if picked == nil || picked.Name == lastPod {
+			picked = pod
+		}
+	}
+	if picked == nil {
+		return nil, fmt.Errorf("no ready pod matches %v", selector)
+	}
+	return picked, nil
+}
+
+// podResolverFor resolves a pod by its selector or app label, the same pod is used when it has neither
+func podResolverFor(pod *Pod, remotePort int, namespace string) podResolver {
+	return func(ctx context.Context, clientset kubernetes.Interface, lastPod string) (string, int, error) {
+		if lastPod == "" {
+			return pod.Name, remotePort, nil
+		}
+		selector, err := labels.Parse(podSelector(pod))
+		if err != nil {
+			return "", 0, err
+		}
+		if selector.Empty() {
+			return lastPod, remotePort, nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		defer cancel()
+		picked, err := pickPod(ctx, clientset, namespace, selector, lastPod)
+		if err != nil {
+			return "", 0, err
+		}
+		log.Printf("Resolved pod %v with %v", picked.Name, selector)
+		return picked.Name, remotePort, nil
+	}
+}
+
+// targetResolverFor resolves a ready backing pod of the workload on every connect.
+// Service ports are translated to the pod's target ports.
+func targetResolverFor(target *Target, remotePort int, namespace string) podResolver {
+	return func(ctx context.Context, clientset kubernetes.Interface, lastPod string) (string, int, error) {
+		ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		defer cancel()
+		var selector labels.Selector
+		var targetPort *intstr.IntOrString
+		switch target.Kind {
+		case KindService:
+			service, err := clientset.CoreV1().Services(namespace).Get(ctx, target.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", 0, err
+			}
+			if len(service.Spec.Selector) == 0 {
+				return "", 0, fmt.Errorf("service %v has no selector", target.Name)
+			}
+			selector = labels.SelectorFromSet(service.Spec.Selector)
+			for _, port := range service.Spec.Ports {
+				if int(port.Port) == remotePort {
+					targetPort = &port.TargetPort
+				}
+			}
+		case KindDeployment:
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, target.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", 0, err
+			}
+			if selector, err = metav1.LabelSelectorAsSelector(deployment.Spec.Selector); err != nil {
+				return "", 0, err
+			}
+		case KindStatefulSet:
+			statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(ctx, target.Name, metav1.GetOptions{})
+			if err != nil {
+				return "", 0, err
+			}
+			if selector, err = metav1.LabelSelectorAsSelector(statefulSet.Spec.Selector); err != nil {
+				return "", 0, err
+			}
+		default:
+			return "", 0, fmt.Errorf("unsupported target kind %v", target.Kind)
+		}
+		pod, err := pickPod(ctx, clientset, namespace, selector, lastPod)
+		if err != nil {
+			return "", 0, err
+		}
+		port, err := podPort(pod, targetPort, remotePort)
+		if err != nil {
+			return "", 0, err
+		}
+		log.Printf("Resolved %v to pod %v", target.Ref(), pod.Name)
+		return pod.Name, port, nil
+	}
+}
+
+// podPort translates service target port (possibly named) to the pod's container port
+func podPort(pod *corev1.Pod, targetPort *intstr.IntOrString, remotePort int) (int, error) {
+	if targetPort == nil {
+		return remotePort, nil
+	}
+	if targetPort.Type == intstr.Int {
+		if targetPort.IntValue() == 0 {
+			return remotePort, nil
+		}
+		return targetPort.IntValue(), nil
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == targetPort.StrVal {
+				return int(port.ContainerPort), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("pod %v has no port named %v", pod.Name, targetPort.StrVal)
+}
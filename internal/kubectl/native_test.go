@@ -0,0 +1,135 @@
+package kubectl
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func mockPod(name string, ready bool, labels map[string]string) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "api", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 3000}}},
+		}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func TestTargetResolverService(t *testing.T) {
+	labels := map[string]string{"app": "api"}
+	clientset := fake.NewSimpleClientset(
+		mockPod("api-1", false, labels),
+		mockPod("api-2", true, labels),
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "ns"},
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http")}},
+			},
+		},
+	)
+	resolve := targetResolverFor(&Target{Kind: KindService, Name: "api"}, 80, "ns")
+	podName, port, err := resolve(context.Background(), clientset, "")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if podName != "api-2" {
+		t.Errorf("Expected `%v` does not match result `%v`", "api-2", podName)
+	}
+	if port != 3000 {
+		t.Errorf("Expected `%v` does not match result `%v`", 3000, port)
+	}
+}
+
+func TestTargetResolverDeployment(t *testing.T) {
+	labels := map[string]string{"app": "worker"}
+	clientset := fake.NewSimpleClientset(
+		mockPod("worker-1", true, labels),
+		mockPod("worker-2", true, labels),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "ns"},
+			Spec:       appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+	)
+	resolve := targetResolverFor(&Target{Kind: KindDeployment, Name: "worker"}, 3000, "ns")
+	// Previously used pod is avoided
+	podName, port, err := resolve(context.Background(), clientset, "worker-1")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if podName != "worker-2" || port != 3000 {
+		t.Errorf("Expected `%v:%v` does not match result `%v:%v`", "worker-2", 3000, podName, port)
+	}
+}
+
+func TestTargetResolverNoReadyPod(t *testing.T) {
+	labels := map[string]string{"app": "worker"}
+	clientset := fake.NewSimpleClientset(
+		mockPod("worker-1", false, labels),
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "ns"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: labels}},
+		},
+	)
+	resolve := targetResolverFor(&Target{Kind: KindStatefulSet, Name: "worker"}, 3000, "ns")
+	if _, _, err := resolve(context.Background(), clientset, ""); err == nil {
+		t.Errorf("Expected no ready pod to fail")
+	}
+}
+
+func TestPodResolverByAppLabel(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		mockPod("api-new", true, map[string]string{"app": "api"}),
+		mockPod("other", true, map[string]string{"app": "other"}),
+	)
+	resolve := podResolverFor(&Pod{Name: "api-old", AppLabel: "api"}, 3000, "ns")
+	podName, _, _ := resolve(context.Background(), clientset, "")
+	if podName != "api-old" {
+		t.Errorf("Expected `%v` does not match result `%v`", "api-old", podName)
+	}
+	podName, _, err := resolve(context.Background(), clientset, "api-old")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if podName != "api-new" {
+		t.Errorf("Expected `%v` does not match result `%v`", "api-new", podName)
+	}
+}
+
+func TestPodResolverBySelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		mockPod("api-web", true, map[string]string{"app": "api", "component": "web"}),
+		mockPod("api-worker-new", true, map[string]string{"app": "api", "component": "worker"}),
+	)
+	resolve := podResolverFor(&Pod{Name: "api-worker-old", AppLabel: "api", Selector: "app=api,component=worker"}, 3000, "ns")
+	podName, _, err := resolve(context.Background(), clientset, "api-worker-old")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if podName != "api-worker-new" {
+		t.Errorf("Expected `%v` does not match result `%v`", "api-worker-new", podName)
+	}
+}
+
+func TestSetForwarder(t *testing.T) {
+	defer SetForwarder(ForwarderNative)
+	if err := SetForwarder(ForwarderKubectl); err != nil || forwarder != ForwarderKubectl {
+		t.Errorf("Expected `%v` does not match result `%v` (%v)", ForwarderKubectl, forwarder, err)
+	}
+	if err := SetForwarder("kubctl"); err == nil || forwarder != ForwarderKubectl {
+		t.Errorf("Expected error for `%v`, forwarder `%v` kept", "kubctl", ForwarderKubectl)
+	}
+}
@@ -0,0 +1,96 @@
+package kubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// TargetKind is a kind of k8s workload port-forward can be resolved from
+type TargetKind string
+
+const (
+	// KindService k8s Service, forwarded ports are service ports
+	KindService TargetKind = "service"
+	// KindDeployment k8s Deployment
+	KindDeployment TargetKind = "deployment"
+	// KindStatefulSet k8s StatefulSet
+	KindStatefulSet TargetKind = "statefulset"
+)
+
+// Target is a k8s workload with stable name, backed by pods.
+// Kubectl resolves it to a ready backing pod on connect.
+type Target struct {
+	Kind  TargetKind
+	Name  string
+	Ports []int
+}
+
+// Ref returns the kubectl resource reference, e.g. `service/api`
+func (t *Target) Ref() string {
+	return fmt.Sprintf("%v/%v", t.Kind, t.Name)
+}
+
+// targetsList is the subset of `kubectl get -o json` output of services, deployments and statefulsets
+type targetsList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Spec struct {
+			// Service ports
+			Ports []struct {
+				Port int `json:"port"`
+			} `json:"ports"`
+			// Deployment and StatefulSet pod template
+			Template struct {
+				Spec struct {
+					Containers []struct {
+						Ports []struct {
+							ContainerPort int `json:"containerPort"`
+						} `json:"ports"`
+					} `json:"containers"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// TargetsList returns the list of k8s workloads of the given kind from the given namespace
+func TargetsList(ctx context.Context, kind TargetKind, namespace string) ([]*Target, error) {
+	out, err := runCommand(ctx, kubectlPath, withKubeconfig("get", string(kind), "--namespace", namespace, "-o", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	return parseTargetsList(kind, out)
+}
+
+func parseTargetsList(kind TargetKind, out string) ([]*Target, error) {
+	list := targetsList{}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, err
+	}
+	targets := []*Target{}
+	for _, item := range list.Items {
+		ports := []int{}
+		for _, port := range item.Spec.Ports {
+			ports = append(ports, port.Port)
+		}
+		for _, container := range item.Spec.Template.Spec.Containers {
+			for _, port := range container.Ports {
+				ports = append(ports, port.ContainerPort)
+			}
+		}
+		targets = append(targets, &Target{Kind: kind, Name: item.Metadata.Name, Ports: ports})
+	}
+	return targets, nil
+}
+
+// TargetPortForward forwards local port to the target and keeps it running
+// like PortForward does. Ready backing pod is resolved on every reconnection.
+func TargetPortForward(ctx context.Context, target *Target, localPort int, remotePort int, namespace string) error {
+	if forwarder == ForwarderNative {
+		return nativePortForward(ctx, targetResolverFor(target, remotePort, namespace), localPort, namespace)
+	}
+	return supervisePortForward(ctx, target.Ref(), func(last string) string { return last }, localPort, remotePort, namespace)
+}
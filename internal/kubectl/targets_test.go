@@ -0,0 +1,77 @@
+package kubectl
+
+import (
+	"context"
+	"testing"
+)
+
+// Trimmed `kubectl get service -o json` output
+var mockServicesList = `{
+	"apiVersion": "v1",
+	"items": [
+		{"metadata": {"name": "api"}, "spec": {"ports": [{"name": "http", "port": 80, "targetPort": 3000}, {"port": 443}]}},
+		{"metadata": {"name": "headless"}, "spec": {"clusterIP": "None"}}
+	],
+	"kind": "List"
+}`
+
+// Trimmed `kubectl get deployment -o json` output
+var mockDeploymentsList = `{
+	"items": [
+		{"metadata": {"name": "worker"}, "spec": {"template": {"spec": {"containers": [
+			{"name": "worker", "ports": [{"containerPort": 3000}]},
+			{"name": "sidecar", "ports": [{"containerPort": 9090}, {"containerPort": 9091}]}
+		]}}}}
+	]
+}`
+
+func TestTargetsListServices(t *testing.T) {
+	unmock := mockRunCommand(mockServicesList)
+	defer unmock()
+	result, err := TargetsList(context.Background(), KindService, "anynamespace")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedItems := []*Target{
+		{Kind: KindService, Name: "api", Ports: []int{80, 443}},
+		{Kind: KindService, Name: "headless", Ports: []int{}},
+	}
+	assertTargets(t, expectedItems, result)
+	if result[0].Ref() != "service/api" {
+		t.Errorf("Expected `%v` does not match result `%v`", "service/api", result[0].Ref())
+	}
+}
+
+func TestTargetsListDeployments(t *testing.T) {
+	unmock := mockRunCommand(mockDeploymentsList)
+	defer unmock()
+	result, err := TargetsList(context.Background(), KindDeployment, "anynamespace")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedItems := []*Target{
+		{Kind: KindDeployment, Name: "worker", Ports: []int{3000, 9090, 9091}},
+	}
+	assertTargets(t, expectedItems, result)
+}
+
+func assertTargets(t *testing.T, expectedItems []*Target, result []*Target) {
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	}
+	for i, expectedItem := range expectedItems {
+		resultItem := result[i]
+		if expectedItem.Name != resultItem.Name || expectedItem.Kind != resultItem.Kind {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, resultItem)
+		}
+		if len(expectedItem.Ports) != len(resultItem.Ports) {
+			t.Errorf("Expected len `%v` does not match result `%v`", expectedItem.Ports, resultItem.Ports)
+			continue
+		}
+		for i, expectedPort := range expectedItem.Ports {
+			if expectedPort != resultItem.Ports[i] {
+				t.Errorf("Expected `%v` does not match result `%v`", expectedPort, resultItem.Ports[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/AckeeCZ/goproxie/internal/stats"
+)
+
+// Metrics recorded by tunnels
+const (
+	// TunnelsActive is 1 while the tunnel forwards, labels type (pod, sql) and listener (local address)
+	TunnelsActive = "goproxie_tunnels_active"
+	// Reconnects counts re-established pod forwards, label type
+	Reconnects = "goproxie_reconnects_total"
+	// Errors counts errors, label type (accept, resolve, dial, stream, forward, certificate, metadata)
+	Errors = "goproxie_errors_total"
+	// CertificateRefresh is the time of the last Cloud SQL ephemeral certificate refresh, label instance
+	CertificateRefresh = "goproxie_sql_certificate_refresh_timestamp_seconds"
+	// CertificateExpiry is the expiration of the current Cloud SQL ephemeral certificate, label instance
+	CertificateExpiry = "goproxie_sql_certificate_expiry_timestamp_seconds"
+)
+
+// Metrics derived from connection statistics
+const (
+	connectionsTotal = "goproxie_connections_total"
+	connectionsOpen  = "goproxie_connections_open"
+	bytesTotal       = "goproxie_bytes_total"
+)
+
+type definition struct {
+	kind string
+	help string
+}
+
+var definitions = map[string]definition{
+	TunnelsActive:      {"gauge", "Whether the tunnel is forwarding."},
+	Reconnects:         {"counter", "Re-established port-forwards."},
+	Errors:             {"counter", "Errors by type."},
+	CertificateRefresh: {"gauge", "Unix time of the last Cloud SQL certificate refresh."},
+	CertificateExpiry:  {"gauge", "Unix time the current Cloud SQL certificate expires."},
+	connectionsTotal:   {"counter", "Accepted connections by target."},
+	connectionsOpen:    {"gauge", "Open connections by target."},
+	bytesTotal:         {"counter", "Bytes by target and direction, in is sent by the local client."},
+}
+
+var (
+	mutex sync.Mutex
+	// Values by metric name and formatted labels
+	values = map[string]map[string]float64{}
+)
+
+// formatLabels formats label name and value pairs, e.g. `{type="dial"}`
+func formatLabels(labels ...string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	items := []string{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		items = append(items, fmt.Sprintf(`%v="%v"`, labels[i], escaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(items, ",") + "}"
+}
+
+func update(name string, labels []string, apply func(value float64) float64) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	samples, ok := values[name]
+	if !ok {
+		samples = map[string]float64{}
+		values[name] = samples
+	}
+	key := formatLabels(labels...)
+	samples[key] = apply(samples[key])
+}
+
+// Add adds delta to the metric, labels are name and value pairs
+func Add(name string, delta float64, labels ...string) {
+	update(name, labels, func(value float64) float64 { return value + delta })
+}
+
+// Inc increments the metric, labels are name and value pairs
+func Inc(name string, labels ...string) {
+	Add(name, 1, labels...)
+}
+
+// Set sets the metric, labels are name and value pairs
+func Set(name string, value float64, labels ...string) {
+	update(name, labels, func(float64) float64 { return value })
+}
+
+// snapshot returns recorded metrics merged with connection statistics
+func snapshot() map[string]map[string]float64 {
+	result := map[string]map[string]float64{}
+	mutex.Lock()
+	for name, samples := range values {
+		result[name] = map[string]float64{}
+		for labels, value := range samples {
+			result[name][labels] = value
+		}
+	}
+	mutex.Unlock()
+	for _, name := range []string{connectionsTotal, connectionsOpen, bytesTotal} {
+		result[name] = map[string]float64{}
+	}
+	for target, totals := range stats.Default.Totals() {
+		result[connectionsTotal][formatLabels("target", target)] = float64(totals.Total)
+		result[connectionsOpen][formatLabels("target", target)] = float64(totals.Open)
+		result[bytesTotal][formatLabels("target", target, "direction", "in")] = float64(totals.BytesIn)
+		result[bytesTotal][formatLabels("target", target, "direction", "out")] = float64(totals.BytesOut)
+	}
+	return result
+}
+
+// Write writes all metrics in the Prometheus text format
+func Write(w io.Writer) {
+	metrics := snapshot()
+	names := []string{}
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if def, ok := definitions[name]; ok {
+			fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, def.help, name, def.kind)
+		}
+		labels := []string{}
+		for label := range metrics[name] {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(w, "%v%v %v\n", name, label, strconv.FormatFloat(metrics[name][label], 'f', -1, 64))
+		}
+	}
+}
+
+// Handler serves metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Write(w)
+	})
+}
@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	Inc(Errors, "type", "dial")
+	Inc(Errors, "type", "dial")
+	Set(TunnelsActive, 1, "type", "sql", "listener", "127.0.0.1:5432")
+	Set(CertificateExpiry, 1729260000, "instance", "acme:europe-west1:db")
+	out := bytes.Buffer{}
+	Write(&out)
+	expectedLines := []string{
+		"# TYPE goproxie_errors_total counter",
+		`goproxie_errors_total{type="dial"} 2`,
+		`goproxie_tunnels_active{type="sql",listener="127.0.0.1:5432"} 1`,
+		`goproxie_sql_certificate_expiry_timestamp_seconds{instance="acme:europe-west1:db"} 1729260000`,
+	}
+	for _, expected := range expectedLines {
+		if !strings.Contains(out.String(), expected+"\n") {
+			t.Errorf("Expected `%v` in metrics\n%v", expected, out.String())
+		}
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	expected := `{target="a\"b"}`
+	if result := formatLabels("target", `a"b`); result != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, result)
+	}
+}
@@ -0,0 +1,72 @@
+package ports
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// KeyRules defines the configuration key of custom guessing rules
+const KeyRules = "ports.rules"
+
+// DefaultPort is the local port guess when no rule matches
+const DefaultPort = 3000
+
+// How many ports above the guess are probed
+const probeRange = 100
+
+// Rule maps a pattern contained in a name or an image to a local port guess
+type Rule struct {
+	Pattern string `mapstructure:"pattern"`
+	Port    int    `mapstructure:"port"`
+}
+
+// DefaultRules mirror the Node.js proxie behaviour, extended by common databases
+var DefaultRules = []Rule{
+	{Pattern: "mongo", Port: 27017},
+	{Pattern: "postgres", Port: 5432},
+	{Pattern: "mysql", Port: 3306},
+	{Pattern: "mariadb", Port: 3306},
+	{Pattern: "redis", Port: 6379},
+	{Pattern: "elastic", Port: 9200},
+}
+
+// Guess returns port of the first rule matching any of the names (pod name, images...).
+// Returns 0 if no rule matches.
+func Guess(rules []Rule, names ...string) int {
+	for _, rule := range rules {
+		pattern := strings.ToLower(rule.Pattern)
+		for _, name := range names {
+			if pattern != "" && strings.Contains(strings.ToLower(name), pattern) {
+				return rule.Port
+			}
+		}
+	}
+	return 0
+}
+
+// IsFree checks whether the port can be bound on the given address
+func IsFree(address string, port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// FindFree returns the first free port on the address starting at the given port, e.g. 3307 if 3306 is taken.
+func FindFree(address string, start int) (int, error) {
+	return FindFreeExcept(address, start, nil)
+}
+
+// FindFreeExcept is same as FindFree, skipping the ports already taken by the caller (e.g. other listeners not bound yet).
+func FindFreeExcept(address string, start int, taken map[int]bool) (int, error) {
+	for port := start; port < start+probeRange && port <= 65535; port++ {
+		if !taken[port] && IsFree(address, port) {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port found in range %v-%v", start, start+probeRange-1)
+}
@@ -0,0 +1,67 @@
+package ports
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGuess(t *testing.T) {
+	cases := []struct {
+		names    []string
+		expected int
+	}{
+		{[]string{"acme-mongodb-0", "bitnami/mongodb:4.2"}, 27017},
+		{[]string{"acme-db-0", "postgres:12"}, 5432},
+		{[]string{"acme-api-74bf544f8b-lzc5b", "eu.gcr.io/acme/api:1.0.0"}, 0},
+	}
+	for _, c := range cases {
+		result := Guess(DefaultRules, c.names...)
+		if result != c.expected {
+			t.Errorf("Expected `%v` does not match result `%v` for %v", c.expected, result, c.names)
+		}
+	}
+}
+
+func TestGuessCustomRulesFirst(t *testing.T) {
+	rules := append([]Rule{{Pattern: "api", Port: 8080}}, DefaultRules...)
+	result := Guess(rules, "mongo-api")
+	if result != 8080 {
+		t.Errorf("Expected `%v` does not match result `%v`", 8080, result)
+	}
+}
+
+func TestFindFree(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	taken := listener.Addr().(*net.TCPAddr).Port
+	result, err := FindFree("127.0.0.1", taken)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if result <= taken {
+		t.Errorf("Expected port above `%v`, got `%v`", taken, result)
+	}
+}
+
+func TestFindFreeExcept(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	bound := listener.Addr().(*net.TCPAddr).Port
+	first, err := FindFreeExcept("127.0.0.1", bound, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	second, err := FindFreeExcept("127.0.0.1", bound, map[int]bool{first: true})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if second <= first {
+		t.Errorf("Expected port above `%v`, got `%v`", first, second)
+	}
+}
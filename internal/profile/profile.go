@@ -0,0 +1,98 @@
+package profile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/AckeeCZ/goproxie/internal/store"
+)
+
+// Dir is the name of the profiles directory inside the goproxie config dir
+const Dir = "profiles"
+
+// Tunnel is a single profile entry.
+// Args are non-interactive goproxie arguments, same as in history records.
+type Tunnel struct {
+	Name string
+	Args []string
+}
+
+// Path resolves the profile name to a file.
+// Existing file paths are used as they are, other names are looked up
+// in the profiles directory of goproxie config dir.
+func Path(name string) string {
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	return path.Join(store.Dir(), Dir, name)
+}
+
+// Load reads tunnels of the given profile.
+func Load(name string) ([]Tunnel, error) {
+	file, err := os.Open(Path(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Parse reads tunnels from profile file content.
+// Each line is a list of goproxie arguments, e.g.
+//
+//	-name=api -project=acme -cluster=production -namespace=api -pod=api -local_port=3000 -remote_port=3000
+//
+// Empty lines and lines starting with `#` are skipped.
+// Tunnel name is taken from `-name`, falls back to pod, target or Cloud SQL instance.
+func Parse(r io.Reader) ([]Tunnel, error) {
+	tunnels := []Tunnel{}
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args := strings.Fields(line)
+		name := TunnelName(args, len(tunnels)+1)
+		if names[name] {
+			return nil, fmt.Errorf("duplicate tunnel name %q, use -name to distinguish tunnels", name)
+		}
+		names[name] = true
+		tunnels = append(tunnels, Tunnel{Name: name, Args: args})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(tunnels) == 0 {
+		return nil, fmt.Errorf("profile contains no tunnels")
+	}
+	return tunnels, nil
+}
+
+// TunnelName picks a human readable name from the tunnel arguments.
+// Index is used when no name can be picked.
+func TunnelName(args []string, index int) string {
+	for _, key := range []string{"name", "pod", "target", "sql_instance"} {
+		if value := ArgValue(args, key); value != "" {
+			return value
+		}
+	}
+	return fmt.Sprintf("tunnel-%v", index)
+}
+
+// ArgValue returns value of `-key=value` or `--key=value` argument
+func ArgValue(args []string, key string) string {
+	for _, arg := range args {
+		for _, prefix := range []string{"-" + key + "=", "--" + key + "="} {
+			if strings.HasPrefix(arg, prefix) {
+				return strings.TrimPrefix(arg, prefix)
+			}
+		}
+	}
+	return ""
+}
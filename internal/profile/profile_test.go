@@ -0,0 +1,50 @@
+package profile
+
+import (
+	"strings"
+	"testing"
+)
+
+var mockProfile = `# Morning set
+-name=api -project=acme -cluster=production -namespace=api -pod=api -local_port=3000 -remote_port=3000
+
+-project=acme -sql_instance=acme:europe-west1:postgres -local_port=5433
+-project=acme -cluster=production -namespace=cache -local_port=6379
+`
+
+func TestParse(t *testing.T) {
+	result, err := Parse(strings.NewReader(mockProfile))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedItems := []Tunnel{
+		{Name: "api", Args: []string{"-name=api", "-project=acme", "-cluster=production", "-namespace=api", "-pod=api", "-local_port=3000", "-remote_port=3000"}},
+		{Name: "acme:europe-west1:postgres", Args: []string{"-project=acme", "-sql_instance=acme:europe-west1:postgres", "-local_port=5433"}},
+		{Name: "tunnel-3", Args: []string{"-project=acme", "-cluster=production", "-namespace=cache", "-local_port=6379"}},
+	}
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	}
+	for i, expectedItem := range expectedItems {
+		if expectedItem.Name != result[i].Name {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem.Name, result[i].Name)
+		}
+		if strings.Join(expectedItem.Args, " ") != strings.Join(result[i].Args, " ") {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem.Args, result[i].Args)
+		}
+	}
+}
+
+func TestParseDuplicateNames(t *testing.T) {
+	_, err := Parse(strings.NewReader("-pod=api\n-pod=api -local_port=3001\n"))
+	if err == nil {
+		t.Errorf("Expected duplicate names to fail")
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	_, err := Parse(strings.NewReader("# nothing here\n\n"))
+	if err == nil {
+		t.Errorf("Expected empty profile to fail")
+	}
+}
@@ -3,29 +3,30 @@ package sqlproxy
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 
+	"github.com/AckeeCZ/goproxie/internal/errs"
 	"github.com/GoogleCloudPlatform/cloudsql-proxy/logging"
 	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/util"
 	"golang.org/x/oauth2/google"
-	sqladmin "google.golang.org/api/sqladmin/v1beta4"
 )
 
 const (
 	host = "https://sqladmin.googleapis.com"
 )
 
-// CreateHTTPAuthClient creats http auth client for google apis
-func CreateHTTPAuthClient() *http.Client {
+// CreateHTTPAuthClient creats http auth client for google apis from Application Default Credentials
+func CreateHTTPAuthClient() (*http.Client, error) {
 	ctx := context.Background()
 	client, err := google.DefaultClient(ctx, proxy.SQLScope)
 	if err != nil {
-		log.Fatal(err)
+		return nil, errs.New(errs.NotAuthenticated, "Application Default Credentials", err)
 	}
-	return client
+	return client, nil
 }
 
 // CloudSQLInstanceType is one of supported POSTGRES, MYSQL, SQLSERVER
@@ -48,6 +49,8 @@ type CloudSQLInstance struct {
 	ConnectionName string
 	Type           CloudSQLInstanceType
 	DefaultPort    int
+	// IPTypes the instance is reachable on, unknown (empty) for blind connections
+	IPTypes []IPType
 }
 
 // GetDefaultPortForType returns default port for given database type
@@ -64,7 +67,7 @@ func GetDefaultPortForType(dbType CloudSQLInstanceType) int {
 	}
 }
 
-func getSQLInstanceType(in *sqladmin.DatabaseInstance) CloudSQLInstanceType {
+func getSQLInstanceType(in *databaseInstance) CloudSQLInstanceType {
 	if strings.Contains(in.DatabaseVersion, "POSTGRES") {
 		return TypePostgres
 	}
@@ -77,42 +80,77 @@ func getSQLInstanceType(in *sqladmin.DatabaseInstance) CloudSQLInstanceType {
 	return TypeUnknown
 }
 
-// GetInstancesList gets list of Cloud SQL instances for given projects
-func GetInstancesList(projects []string) ([]CloudSQLInstance, error) {
-	ctx := context.Background()
-	client := CreateHTTPAuthClient()
+// resolveTypes looks up unknown types of instances given by connection name, e.g. blind connections.
+// Types stay unknown when the instance can't be read.
+func resolveTypes(ctx context.Context, client *http.Client, listeners []Listener) {
+	for i, listener := range listeners {
+		if listener.Instance.Type != TypeUnknown && listener.Instance.Type != "" {
+			continue
+		}
+		project, _, instanceName := util.SplitName(listener.Instance.ConnectionName)
+		in := databaseInstance{}
+		if err := getJSON(ctx, client, fmt.Sprintf("projects/%v/instances/%v", project, instanceName), &in); err != nil {
+			logging.Errorf("Couldn't get type of %v: %v", listener.Instance.ConnectionName, err)
+			continue
+		}
+		listeners[i].Instance.Type = getSQLInstanceType(&in)
+	}
+}
+
+// listInstances returns all instances of the project, page by page
+func listInstances(ctx context.Context, client *http.Client, project string) ([]*databaseInstance, error) {
+	instances := []*databaseInstance{}
+	pageToken := ""
+	for {
+		page := struct {
+			Items         []*databaseInstance `json:"items"`
+			NextPageToken string              `json:"nextPageToken"`
+		}{}
+		path := fmt.Sprintf("projects/%v/instances?pageToken=%v", project, url.QueryEscape(pageToken))
+		if err := getJSON(ctx, client, path, &page); err != nil {
+			return nil, err
+		}
+		instances = append(instances, page.Items...)
+		if page.NextPageToken == "" {
+			return instances, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// GetInstancesList gets list of Cloud SQL instances for given projects, requests are canceled when ctx is done
+func GetInstancesList(ctx context.Context, projects []string) ([]CloudSQLInstance, error) {
 	if len(projects) == 0 {
 		// No projects requested.
 		return nil, nil
 	}
-
-	sql, err := sqladmin.New(client)
+	client, err := CreateHTTPAuthClient()
 	if err != nil {
 		return nil, err
 	}
-	if host != "" {
-		sql.BasePath = host
-	}
 
 	ch := make(chan CloudSQLInstance)
+	var mutex sync.Mutex
+	var listErr error
 	var wg sync.WaitGroup
 	wg.Add(len(projects))
 	for _, proj := range projects {
 		proj := proj
 		go func() {
-			err := sql.Instances.List(proj).Pages(ctx, func(r *sqladmin.InstancesListResponse) error {
-				for _, in := range r.Items {
-					// The Proxy is only support on Second Gen
-					if in.BackendType == "SECOND_GEN" {
-						connName := fmt.Sprintf("%s:%s:%s", in.Project, in.Region, in.Name)
-						dbType := getSQLInstanceType(in)
-						ch <- CloudSQLInstance{ConnectionName: connName, Type: dbType, DefaultPort: GetDefaultPortForType(dbType)}
-					}
-				}
-				return nil
-			})
+			instances, err := listInstances(ctx, client, proj)
 			if err != nil {
 				logging.Errorf("Error listing instances in %v: %v", proj, err)
+				mutex.Lock()
+				listErr = err
+				mutex.Unlock()
+			}
+			for _, in := range instances {
+				// The Proxy is only support on Second Gen
+				if in.BackendType == "SECOND_GEN" {
+					connName := fmt.Sprintf("%s:%s:%s", in.Project, in.Region, in.Name)
+					dbType := getSQLInstanceType(in)
+					ch <- CloudSQLInstance{ConnectionName: connName, Type: dbType, DefaultPort: GetDefaultPortForType(dbType), IPTypes: in.ipTypes()}
+				}
 			}
 			wg.Done()
 		}()
@@ -125,8 +163,12 @@ func GetInstancesList(projects []string) ([]CloudSQLInstance, error) {
 	for x := range ch {
 		ret = append(ret, x)
 	}
+	// Instances of other projects are still usable
+	if len(ret) == 0 && listErr != nil {
+		return nil, listErr
+	}
 	if len(ret) == 0 {
-		return nil, fmt.Errorf("no Cloud SQL Instances found in these projects: %v", projects)
+		return nil, errs.New(errs.NotFound, "Cloud SQL instances", fmt.Errorf("no Cloud SQL Instances found in these projects: %v", projects))
 	}
 	return ret, nil
 }
@@ -0,0 +1,92 @@
+package sqlproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/logging"
+)
+
+// Control commands read from the input of a running proxy
+const (
+	// commandAdd opens listener of another instance, `add <connection name>[=<local port>]`
+	commandAdd = "add"
+	// commandRemove closes listeners of the instance, `remove <connection name>`.
+	// Existing connections are kept open.
+	commandRemove = "remove"
+	// commandList prints the current listeners
+	commandList = "list"
+)
+
+// control reads commands line by line and sends updated listeners configuration.
+// Types of added instances are looked up with the client. Returns on the end of input, listeners are kept open.
+func control(in io.Reader, client *http.Client, listeners []Listener, options ProxyOptions, updates chan<- []instanceConfig) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == commandList {
+			logging.Infof("Listening for %v", FormatListeners(listeners))
+			continue
+		}
+		updated, err := applyCommand(listeners, options, fields)
+		if err != nil {
+			logging.Errorf("%v", err)
+			continue
+		}
+		if fields[0] == commandAdd {
+			// Socket path and ready message depend on the type
+			ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+			resolveTypes(ctx, client, updated[len(listeners):])
+			cancel()
+		}
+		cfgs, err := listenersConfigs(updated, options)
+		if err != nil {
+			logging.Errorf("%v", err)
+			continue
+		}
+		listeners = updated
+		updates <- cfgs
+	}
+}
+
+// applyCommand returns listeners updated by the command
+func applyCommand(listeners []Listener, options ProxyOptions, fields []string) ([]Listener, error) {
+	usage := fmt.Errorf("unknown command %q, use `%v <connection name>[=<local port>]`, `%v <connection name>` or `%v`", strings.Join(fields, " "), commandAdd, commandRemove, commandList)
+	switch {
+	case fields[0] == commandAdd && len(fields) == 2:
+		added, err := ParseListeners(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, listener := range added {
+			if listener.LocalPort == 0 && options.SocketDir == "" {
+				return nil, fmt.Errorf("local port of Cloud SQL instance %v is required, use `%v %v=<local port>`", listener.Instance.ConnectionName, commandAdd, listener.Instance.ConnectionName)
+			}
+			for _, existing := range listeners {
+				if existing.Instance.ConnectionName == listener.Instance.ConnectionName {
+					return nil, fmt.Errorf("Cloud SQL instance %v is already open", listener.Instance.ConnectionName)
+				}
+			}
+		}
+		return append(append([]Listener{}, listeners...), added...), nil
+	case fields[0] == commandRemove && len(fields) == 2:
+		updated := []Listener{}
+		for _, listener := range listeners {
+			if listener.Instance.ConnectionName != fields[1] {
+				updated = append(updated, listener)
+			}
+		}
+		if len(updated) == len(listeners) {
+			return nil, fmt.Errorf("Cloud SQL instance %v is not open", fields[1])
+		}
+		return updated, nil
+	}
+	return nil, usage
+}
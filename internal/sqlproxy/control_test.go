@@ -0,0 +1,95 @@
+package sqlproxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// apiClient sends all requests to the handler instead of the sqladmin API, the returned func stops its server
+func apiClient(handler http.HandlerFunc) (*http.Client, func()) {
+	server := httptest.NewServer(handler)
+	serverURL, _ := url.Parse(server.URL)
+	return &http.Client{Transport: rewriteTransport{serverURL}}, server.Close
+}
+
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (r rewriteTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request.URL.Scheme = r.target.Scheme
+	request.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+// postgresAPI reports all instances as PostgreSQL
+func postgresAPI(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"databaseVersion": "POSTGRES_13"}`))
+}
+
+func TestControl(t *testing.T) {
+	listeners := []Listener{{Instance: CloudSQLInstance{ConnectionName: "acme:europe-west1:primary"}, LocalPort: 5432}}
+	in := strings.NewReader("add acme:europe-west1:replica=5433\nadd acme:europe-west1:analytics\nremove acme:europe-west1:primary\n")
+	updates := make(chan []instanceConfig)
+	client, closeAPI := apiClient(postgresAPI)
+	defer closeAPI()
+	go func() {
+		control(in, client, listeners, ProxyOptions{}, updates)
+		close(updates)
+	}()
+	expectedUpdates := [][]string{
+		{"127.0.0.1:5432", "127.0.0.1:5433"},
+		// analytics is rejected, local port is missing
+		{"127.0.0.1:5433"},
+	}
+	i := 0
+	for cfgs := range updates {
+		if i >= len(expectedUpdates) {
+			t.Fatalf("Unexpected update `%v`", cfgs)
+		}
+		addresses := []string{}
+		for _, cfg := range cfgs {
+			addresses = append(addresses, cfg.Address)
+		}
+		if strings.Join(addresses, ",") != strings.Join(expectedUpdates[i], ",") {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedUpdates[i], addresses)
+		}
+		i++
+	}
+	if i != len(expectedUpdates) {
+		t.Errorf("Expected `%v` updates, got `%v`", len(expectedUpdates), i)
+	}
+}
+
+func TestApplyCommandErrors(t *testing.T) {
+	listeners := []Listener{{Instance: CloudSQLInstance{ConnectionName: "acme:europe-west1:primary"}, LocalPort: 5432}}
+	for _, command := range []string{"add acme:europe-west1:primary=5433", "remove acme:europe-west1:replica", "restart"} {
+		if _, err := applyCommand(listeners, ProxyOptions{}, strings.Fields(command)); err == nil {
+			t.Errorf("Expected error for `%v`", command)
+		}
+	}
+}
+
+func TestControlResolvesAddedTypes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client, closeAPI := apiClient(postgresAPI)
+	defer closeAPI()
+	in := strings.NewReader("add acme:europe-west1:replica\n")
+	updates := make(chan []instanceConfig, 1)
+	control(in, client, []Listener{}, ProxyOptions{SocketDir: dir}, updates)
+	cfgs := <-updates
+	expected := filepath.Join(dir, "acme:europe-west1:replica", ".s.PGSQL.5432")
+	if len(cfgs) != 1 || cfgs[0].Address != expected || cfgs[0].Type != TypePostgres {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, cfgs)
+	}
+}
@@ -0,0 +1,26 @@
+package sqlproxy
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// DSN returns URL of the local endpoint for database clients and ORMs, without credentials and database name.
+// Address is host:port or Unix socket path. Empty for unknown types and sockets other than PostgreSQL.
+func DSN(dbType CloudSQLInstanceType, address string) string {
+	socket := strings.HasPrefix(address, "/")
+	switch {
+	case dbType == TypePostgres && socket:
+		// libpq takes socket directory, file name is derived from the port
+		return "postgres:///?host=" + url.QueryEscape(filepath.Dir(address))
+	case dbType == TypePostgres:
+		return "postgres://" + address
+	case dbType == TypeMySQL && !socket:
+		return "mysql://" + address
+	case dbType == TypeSQLServer && !socket:
+		return "sqlserver://" + address
+	default:
+		return ""
+	}
+}
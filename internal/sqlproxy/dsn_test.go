@@ -0,0 +1,23 @@
+package sqlproxy
+
+import "testing"
+
+func TestDSN(t *testing.T) {
+	items := []struct {
+		dbType   CloudSQLInstanceType
+		address  string
+		expected string
+	}{
+		{TypePostgres, "127.0.0.1:5432", "postgres://127.0.0.1:5432"},
+		{TypePostgres, "/tmp/cloudsql/acme:europe-west1:db/.s.PGSQL.5432", "postgres:///?host=%2Ftmp%2Fcloudsql%2Facme%3Aeurope-west1%3Adb"},
+		{TypeMySQL, "[::1]:3306", "mysql://[::1]:3306"},
+		{TypeMySQL, "/tmp/cloudsql/acme:europe-west1:db", ""},
+		{TypeSQLServer, "127.0.0.1:1433", "sqlserver://127.0.0.1:1433"},
+		{TypeUnknown, "127.0.0.1:3307", ""},
+	}
+	for _, item := range items {
+		if result := DSN(item.dbType, item.address); result != item.expected {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+}
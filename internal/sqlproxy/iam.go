@@ -0,0 +1,116 @@
+package sqlproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/certs"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/util"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Scope of OAuth2 tokens used for IAM database login
+const loginScope = "https://www.googleapis.com/auth/sqlservice.login"
+
+// iamCertSource requests ephemeral certificates carrying the user's OAuth2 token,
+// so the database login is authorized by the Google identity (IAM database authentication).
+// Same as `-enable_iam_login` of the upstream proxy.
+// Instance metadata (Remote) is served by the regular certificate source.
+type iamCertSource struct {
+	*certs.RemoteCertSource
+	client      *http.Client
+	tokenSource oauth2.TokenSource
+	key         *rsa.PrivateKey
+}
+
+// ephemeralRequest is sqladmin createEphemeral request body, the generated client lacks the access token
+type ephemeralRequest struct {
+	PublicKey   string `json:"public_key"`
+	AccessToken string `json:"access_token"`
+}
+
+type ephemeralResponse struct {
+	Cert string `json:"cert"`
+}
+
+func newIAMCertSource(client *http.Client, opts certs.RemoteOpts) (*iamCertSource, error) {
+	tokenSource, err := google.DefaultTokenSource(context.Background(), proxy.SQLScope, loginScope)
+	if err != nil {
+		return nil, err
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &iamCertSource{
+		RemoteCertSource: certs.NewCertSourceOpts(client, opts),
+		client:           client,
+		tokenSource:      tokenSource,
+		key:              key,
+	}, nil
+}
+
+// Local returns ephemeral certificate valid at most until the token expires
+func (s *iamCertSource) Local(instance string) (ret tls.Certificate, err error) {
+	token, err := s.tokenSource.Token()
+	if err != nil {
+		return ret, err
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return ret, err
+	}
+	body, err := json.Marshal(ephemeralRequest{
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Bytes: pkix, Type: "RSA PUBLIC KEY"})),
+		AccessToken: token.AccessToken,
+	})
+	if err != nil {
+		return ret, err
+	}
+	project, _, name := util.SplitName(instance)
+	url := fmt.Sprintf("%v/sql/v1beta4/projects/%v/instances/%v/createEphemeral", host, project, name)
+	response, err := s.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return ret, err
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return ret, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return ret, fmt.Errorf("createEphemeral for %q failed with %v: %s", instance, response.Status, data)
+	}
+	ephemeral := ephemeralResponse{}
+	if err := json.Unmarshal(data, &ephemeral); err != nil {
+		return ret, err
+	}
+	block, _ := pem.Decode([]byte(ephemeral.Cert))
+	if block == nil {
+		return ret, fmt.Errorf("invalid PEM: %v", ephemeral.Cert)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ret, fmt.Errorf("couldn't parse ephemeral certificate for instance %q: %v", instance, err)
+	}
+	// Proxy client refreshes the certificate by its expiration
+	if !token.Expiry.IsZero() && token.Expiry.Before(cert.NotAfter) {
+		cert.NotAfter = token.Expiry
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		PrivateKey:  s.key,
+		Leaf:        cert,
+	}, nil
+}
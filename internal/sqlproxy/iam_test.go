@@ -0,0 +1,67 @@
+package sqlproxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ephemeralCert returns PEM certificate of the key valid until notAfter, as createEphemeral does
+func ephemeralCert(t *testing.T, key *rsa.PrivateKey, notAfter time.Time) string {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ephemeral"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestIAMCertSourceLocal(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenExpiry := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+	cert := ephemeralCert(t, key, time.Now().Add(time.Hour))
+	requested := ephemeralRequest{}
+	requestedPath := ""
+	client, closeAPI := apiClient(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&requested)
+		json.NewEncoder(w).Encode(ephemeralResponse{Cert: cert})
+	})
+	defer closeAPI()
+	source := &iamCertSource{
+		client:      client,
+		tokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "user-token", Expiry: tokenExpiry}),
+		key:         key,
+	}
+	result, err := source.Local("acme:europe-west1:primary")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedPath := "/sql/v1beta4/projects/acme/instances/primary/createEphemeral"
+	if requestedPath != expectedPath {
+		t.Errorf("Expected `%v` does not match result `%v`", expectedPath, requestedPath)
+	}
+	if requested.AccessToken != "user-token" {
+		t.Errorf("Expected `%v` does not match result `%v`", "user-token", requested.AccessToken)
+	}
+	if !result.Leaf.NotAfter.Equal(tokenExpiry) {
+		t.Errorf("Expected `%v` does not match result `%v`", tokenExpiry, result.Leaf.NotAfter)
+	}
+}
@@ -0,0 +1,227 @@
+package sqlproxy
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/util"
+)
+
+// IPType is a type of Cloud SQL instance address the proxy connects to
+type IPType string
+
+const (
+	// IPTypePublic public IP address
+	IPTypePublic IPType = "PUBLIC"
+	// IPTypePrivate private IP address in the VPC
+	IPTypePrivate IPType = "PRIVATE"
+	// IPTypePSC Private Service Connect endpoint, resolved from the instance DNS name
+	IPTypePSC IPType = "PSC"
+)
+
+// IPTypes lists all supported IP types in the default order of preference
+var IPTypes = []IPType{IPTypePublic, IPTypePrivate, IPTypePSC}
+
+// DefaultIPTypes are tried in order when no IP type is requested, same as the upstream proxy
+var DefaultIPTypes = []IPType{IPTypePublic, IPTypePrivate}
+
+// databaseInstance is the subset of sqladmin DatabaseInstance.
+// Decoded by hand, the generated client predates Private Service Connect.
+type databaseInstance struct {
+	Name            string `json:"name"`
+	Project         string `json:"project"`
+	Region          string `json:"region"`
+	BackendType     string `json:"backendType"`
+	DatabaseVersion string `json:"databaseVersion"`
+	DNSName         string `json:"dnsName"`
+	IPAddresses     []struct {
+		Type      string `json:"type"`
+		IPAddress string `json:"ipAddress"`
+	} `json:"ipAddresses"`
+	Settings struct {
+		IPConfiguration struct {
+			PscConfig struct {
+				PscEnabled bool `json:"pscEnabled"`
+			} `json:"pscConfig"`
+		} `json:"ipConfiguration"`
+	} `json:"settings"`
+	ServerCaCert struct {
+		Cert string `json:"cert"`
+	} `json:"serverCaCert"`
+}
+
+// address returns the instance address of the IP type, empty if the instance has none
+func (in *databaseInstance) address(ipType IPType) string {
+	if ipType == IPTypePSC {
+		if in.Settings.IPConfiguration.PscConfig.PscEnabled {
+			return strings.TrimSuffix(in.DNSName, ".")
+		}
+		return ""
+	}
+	// Public address is called PRIMARY by the API
+	apiType := string(ipType)
+	if ipType == IPTypePublic {
+		apiType = "PRIMARY"
+	}
+	for _, ip := range in.IPAddresses {
+		if strings.ToUpper(ip.Type) == apiType {
+			return ip.IPAddress
+		}
+	}
+	return ""
+}
+
+// ipTypes returns IP types the instance is reachable on
+func (in *databaseInstance) ipTypes() []IPType {
+	types := []IPType{}
+	for _, ipType := range IPTypes {
+		if in.address(ipType) != "" {
+			types = append(types, ipType)
+		}
+	}
+	return types
+}
+
+// statusKinds classifies failed sqladmin requests
+var statusKinds = map[int]errs.Kind{
+	http.StatusUnauthorized: errs.NotAuthenticated,
+	http.StatusForbidden:    errs.PermissionDenied,
+	http.StatusNotFound:     errs.NotFound,
+}
+
+// How long requests made by the running proxy may take, e.g. instance lookups on connect
+const requestTimeout = 30 * time.Second
+
+// getJSON decodes response of sqladmin GET request, path is relative to the API version, e.g. `projects/acme/instances`.
+// The request is canceled when ctx is done.
+func getJSON(ctx context.Context, client *http.Client, path string, v interface{}) error {
+	op := "GET " + path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%v/sql/v1beta4/%v", host, path), nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return errs.Classify(op, "", err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return errs.Classify(op, "", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		err := fmt.Errorf("failed with %v: %s", response.Status, data)
+		if kind, ok := statusKinds[response.StatusCode]; ok {
+			return errs.New(kind, op, err)
+		}
+		return errs.Classify(op, "", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ParseIPTypes parses comma separated list of IP types, e.g. `PRIVATE,PUBLIC`.
+// Returns nil for empty value, meaning DefaultIPTypes.
+func ParseIPTypes(value string) ([]IPType, error) {
+	if value == "" {
+		return nil, nil
+	}
+	types := []IPType{}
+	for _, item := range strings.Split(value, ",") {
+		ipType := IPType(strings.ToUpper(strings.TrimSpace(item)))
+		if !containsIPType(IPTypes, ipType) {
+			return nil, fmt.Errorf("unknown Cloud SQL IP type %q, use one of %v", item, FormatIPTypes(IPTypes))
+		}
+		types = append(types, ipType)
+	}
+	return types, nil
+}
+
+// FormatIPTypes formats IP types as accepted by ParseIPTypes
+func FormatIPTypes(types []IPType) string {
+	items := []string{}
+	for _, ipType := range types {
+		items = append(items, string(ipType))
+	}
+	return strings.Join(items, ",")
+}
+
+// ValidateIPTypes checks the instance is reachable on at least one of the requested IP types
+func ValidateIPTypes(requested []IPType, available []IPType) error {
+	for _, ipType := range requested {
+		if containsIPType(available, ipType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("Cloud SQL instance has no %v address, available IP types are %v", FormatIPTypes(requested), FormatIPTypes(available))
+}
+
+func containsIPType(types []IPType, ipType IPType) bool {
+	for _, t := range types {
+		if t == ipType {
+			return true
+		}
+	}
+	return false
+}
+
+// certIPTypes returns IP types for the upstream certificate source, which knows only public and private IPs
+func certIPTypes(types []IPType) []string {
+	items := []string{}
+	for _, ipType := range types {
+		if ipType != IPTypePSC {
+			items = append(items, string(ipType))
+		}
+	}
+	return items
+}
+
+// pscCertSource resolves instance address in the order of IP types including Private Service Connect,
+// which the upstream certificate source does not support. Local certificates are served by the wrapped source.
+type pscCertSource struct {
+	proxy.CertSource
+	client  *http.Client
+	ipTypes []IPType
+}
+
+// Remote returns the instance's CA certificate, address of the first available IP type, and name
+func (s *pscCertSource) Remote(instance string) (cert *x509.Certificate, addr, name string, err error) {
+	project, _, instanceName := util.SplitName(instance)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	in := databaseInstance{}
+	if err := getJSON(ctx, s.client, fmt.Sprintf("projects/%v/instances/%v", project, instanceName), &in); err != nil {
+		return nil, "", "", err
+	}
+	for _, ipType := range s.ipTypes {
+		if addr = in.address(ipType); addr != "" {
+			break
+		}
+	}
+	if addr == "" {
+		return nil, "", "", fmt.Errorf("instance %v has no %v address, available IP types are %v", instance, FormatIPTypes(s.ipTypes), FormatIPTypes(in.ipTypes()))
+	}
+	// Proxy client dials IP address, PSC endpoint is published as DNS name
+	if net.ParseIP(addr) == nil {
+		addrs, err := net.LookupHost(addr)
+		if err != nil {
+			return nil, "", "", err
+		}
+		addr = addrs[0]
+	}
+	block, _ := pem.Decode([]byte(in.ServerCaCert.Cert))
+	if block == nil {
+		return nil, "", "", fmt.Errorf("invalid PEM: %v", in.ServerCaCert.Cert)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	return cert, addr, project + ":" + instanceName, err
+}
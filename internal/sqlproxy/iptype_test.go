@@ -0,0 +1,54 @@
+package sqlproxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseIPTypes(t *testing.T) {
+	result, err := ParseIPTypes("private, psc")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := "PRIVATE,PSC"
+	if FormatIPTypes(result) != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, FormatIPTypes(result))
+	}
+	if _, err := ParseIPTypes("PUBLIC,VPN"); err == nil {
+		t.Errorf("Expected error for unknown IP type")
+	}
+	if result, _ := ParseIPTypes(""); result != nil {
+		t.Errorf("Expected nil for empty value, got `%v`", result)
+	}
+}
+
+func TestValidateIPTypes(t *testing.T) {
+	available := []IPType{IPTypePrivate}
+	if err := ValidateIPTypes([]IPType{IPTypePublic, IPTypePrivate}, available); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := ValidateIPTypes([]IPType{IPTypePSC}, available); err == nil {
+		t.Errorf("Expected error for unavailable IP type")
+	}
+}
+
+func TestDatabaseInstanceIPTypes(t *testing.T) {
+	data := `{
+		"name": "db",
+		"dnsName": "abc.europe-west1.sql.goog.",
+		"ipAddresses": [{"type": "PRIVATE", "ipAddress": "10.0.0.3"}, {"type": "OUTGOING", "ipAddress": "34.0.0.1"}],
+		"settings": {"ipConfiguration": {"pscConfig": {"pscEnabled": true}}}
+	}`
+	in := databaseInstance{}
+	if err := json.Unmarshal([]byte(data), &in); err != nil {
+		t.Fatal(err)
+	}
+	expected := "PRIVATE,PSC"
+	if result := FormatIPTypes(in.ipTypes()); result != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, result)
+	}
+	expected = "abc.europe-west1.sql.goog"
+	if result := in.address(IPTypePSC); result != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, result)
+	}
+}
@@ -0,0 +1,68 @@
+package sqlproxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/logging"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+)
+
+// releasingConn releases its connection slot once closed
+type releasingConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *releasingConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// limitConnections passes at most max connections open at once, others wait in the queue
+// until an open one is closed. Upstream proxy client refuses them instead. 0 means no limit.
+func limitConnections(src <-chan proxy.Conn, max uint64) <-chan proxy.Conn {
+	if max == 0 {
+		return src
+	}
+	slots := make(chan struct{}, max)
+	dst := make(chan proxy.Conn)
+	go func() {
+		for conn := range src {
+			select {
+			case slots <- struct{}{}:
+			default:
+				logging.Infof("Connection limit %d reached, new connection for %q is queued", max, conn.Instance)
+				slots <- struct{}{}
+			}
+			conn.Conn = &releasingConn{Conn: conn.Conn, release: func() { <-slots }}
+			dst <- conn
+		}
+		close(dst)
+	}()
+	return dst
+}
+
+// drain waits up to termTimeout for open connections of the client to close, reporting their count
+func drain(client *proxy.Client, termTimeout time.Duration) error {
+	deadline := time.Now().Add(termTimeout)
+	for {
+		open := atomic.LoadUint64(&client.ConnectionsCounter)
+		if open == 0 {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return fmt.Errorf("%d connections still open after waiting for %v", open, termTimeout)
+		}
+		logging.Infof("Waiting for %d open connections to close, %v left", open, remaining.Round(time.Second))
+		if remaining > time.Second {
+			remaining = time.Second
+		}
+		time.Sleep(remaining)
+	}
+}
@@ -0,0 +1,47 @@
+package sqlproxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+)
+
+func TestLimitConnectionsQueues(t *testing.T) {
+	src := make(chan proxy.Conn)
+	dst := limitConnections(src, 1)
+	go func() {
+		for i := 0; i < 2; i++ {
+			local, _ := net.Pipe()
+			src <- proxy.Conn{Instance: "acme:europe-west1:db", Conn: local}
+		}
+		close(src)
+	}()
+	first := <-dst
+	select {
+	case <-dst:
+		t.Fatal("Expected second connection to wait in the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	first.Conn.Close()
+	// Second close must not release another slot
+	first.Conn.Close()
+	select {
+	case second := <-dst:
+		second.Conn.Close()
+	case <-time.After(time.Second):
+		t.Fatal("Expected queued connection after the first one closed")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	client := &proxy.Client{}
+	if err := drain(client, 0); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	client.ConnectionsCounter = 1
+	if err := drain(client, 10*time.Millisecond); err == nil {
+		t.Errorf("Expected error for open connection")
+	}
+}
@@ -0,0 +1,48 @@
+package sqlproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/metrics"
+	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
+	netproxy "golang.org/x/net/proxy"
+)
+
+// metricsCertSource records certificate refreshes and errors of the wrapped source
+type metricsCertSource struct {
+	proxy.CertSource
+}
+
+// Local is called by the proxy client on every certificate refresh
+func (s *metricsCertSource) Local(instance string) (tls.Certificate, error) {
+	cert, err := s.CertSource.Local(instance)
+	if err != nil {
+		metrics.Inc(metrics.Errors, "type", "certificate")
+		return cert, err
+	}
+	metrics.Set(metrics.CertificateRefresh, float64(time.Now().Unix()), "instance", instance)
+	if cert.Leaf != nil {
+		metrics.Set(metrics.CertificateExpiry, float64(cert.Leaf.NotAfter.Unix()), "instance", instance)
+	}
+	return cert, nil
+}
+
+func (s *metricsCertSource) Remote(instance string) (*x509.Certificate, string, string, error) {
+	cert, addr, name, err := s.CertSource.Remote(instance)
+	if err != nil {
+		metrics.Inc(metrics.Errors, "type", "metadata")
+	}
+	return cert, addr, name, err
+}
+
+// dial connects to the instance using the proxy client default dialer, honoring ALL_PROXY
+func dial(network, address string) (net.Conn, error) {
+	conn, err := netproxy.FromEnvironment().Dial(network, address)
+	if err != nil {
+		metrics.Inc(metrics.Errors, "type", "dial")
+	}
+	return conn, err
+}
@@ -6,15 +6,21 @@ package sqlproxy
 // Not happy with it, but I cant import it due to "is a program, not an importable package"
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/AckeeCZ/goproxie/internal/health"
+	"github.com/AckeeCZ/goproxie/internal/metrics"
+	"github.com/AckeeCZ/goproxie/internal/stats"
 	"github.com/GoogleCloudPlatform/cloudsql-proxy/logging"
 	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/certs"
 	"github.com/GoogleCloudPlatform/cloudsql-proxy/proxy/proxy"
@@ -28,24 +34,46 @@ const (
 
 const dialersTimeout = time.Minute
 
+// Address local listeners are bound to
+var bindAddress = "127.0.0.1"
+
+// SetBindAddress sets the address local listeners are bound to.
+func SetBindAddress(address string) {
+	bindAddress = address
+}
+
 type instanceConfig struct {
 	Instance         string
+	Type             CloudSQLInstanceType
 	Network, Address string
 }
 
 // listenInstance starts listening on a new unix socket in dir to connect to the
 // specified instance. New connections to this socket are sent to dst.
 func listenInstance(dst chan<- proxy.Conn, cfg instanceConfig) (net.Listener, error) {
+	if cfg.Network == "unix" {
+		// Socket file left by a proxy that did not exit cleanly
+		if err := os.Remove(cfg.Address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
 	l, err := net.Listen(cfg.Network, cfg.Address)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.Network == "unix" {
+		// Allow apps running under other users (e.g. in containers) to connect
+		if err := os.Chmod(cfg.Address, 0777|os.ModeSocket); err != nil {
+			logging.Errorf("couldn't update permissions for socket file %q: %v; other users may be unable to connect", cfg.Address, err)
+		}
+	}
 
 	go func() {
 		for {
 			start := time.Now()
 			c, err := l.Accept()
 			if err != nil {
+				metrics.Inc(metrics.Errors, "type", "accept")
 				logging.Errorf("Error in accept for %q on %v: %v", cfg, cfg.Address, err)
 				if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
 					d := 10*time.Millisecond - time.Since(start)
@@ -65,60 +93,55 @@ func listenInstance(dst chan<- proxy.Conn, cfg instanceConfig) (net.Listener, er
 				clientConn.SetKeepAlivePeriod(1 * time.Minute)
 
 			}
-			dst <- proxy.Conn{cfg.Instance, c}
+			dst <- proxy.Conn{Instance: cfg.Instance, Conn: stats.Track(c, cfg.Instance)}
 		}
 	}()
 
 	logging.Infof("Listening on %s for %s", cfg.Address, cfg.Instance)
+	metrics.Set(metrics.TunnelsActive, 1, "type", "sql", "listener", l.Addr().String())
+	health.Set(health.Tunnel{Type: "sql", Listener: l.Addr().String(), Target: cfg.Instance, Detail: "connecting"})
 	return l, nil
 }
 
-func watchInstancesLoop(dir string, dst chan<- proxy.Conn, updates <-chan string, static map[string]net.Listener, cl *http.Client, cfgs []instanceConfig) {
-	dynamicInstances := make(map[string]net.Listener)
-	for range updates {
-		list := cfgs
+// listenerKey identifies local listener, instance may have both TCP and Unix socket listener
+func listenerKey(cfg instanceConfig) string {
+	return cfg.Network + ":" + cfg.Address
+}
 
+func watchInstancesLoop(dst chan<- proxy.Conn, updates <-chan []instanceConfig, open map[string]net.Listener, onListen func(cfg instanceConfig, address string)) {
+	for cfgs := range updates {
 		stillOpen := make(map[string]net.Listener)
-		for _, cfg := range list {
-			instance := cfg.Instance
-
-			// If the instance is specified in the static list don't do anything:
-			// it's already open and should stay open forever.
-			if _, ok := static[instance]; ok {
-				continue
-			}
-
-			if l, ok := dynamicInstances[instance]; ok {
-				delete(dynamicInstances, instance)
-				stillOpen[instance] = l
+		for _, cfg := range cfgs {
+			key := listenerKey(cfg)
+			if l, ok := open[key]; ok {
+				delete(open, key)
+				stillOpen[key] = l
 				continue
 			}
 
 			l, err := listenInstance(dst, cfg)
 			if err != nil {
-				logging.Errorf("Couldn't open socket for %q: %v", instance, err)
+				logging.Errorf("Couldn't open socket for %q: %v", cfg.Instance, err)
 				continue
 			}
-			stillOpen[instance] = l
+			stillOpen[key] = l
+			onListen(cfg, l.Addr().String())
 		}
 
-		// Any instance in dynamicInstances was not in the most recent metadata
-		// update. Clean up those instances' sockets by closing them; note that
+		// Any listener left in open was not in the most recent update.
+		// Clean up those by closing them; note that
 		// this does not affect any existing connections instance.
-		for instance, listener := range dynamicInstances {
-			logging.Infof("Closing socket for instance %v", instance)
+		for _, listener := range open {
+			logging.Infof("Closing %v", listener.Addr())
+			metrics.Set(metrics.TunnelsActive, 0, "type", "sql", "listener", listener.Addr().String())
+			health.Remove(listener.Addr().String())
 			listener.Close()
 		}
 
-		dynamicInstances = stillOpen
+		open = stillOpen
 	}
 
-	for _, v := range static {
-		if err := v.Close(); err != nil {
-			logging.Errorf("Error closing %q: %v", v.Addr(), err)
-		}
-	}
-	for _, v := range dynamicInstances {
+	for _, v := range open {
 		if err := v.Close(); err != nil {
 			logging.Errorf("Error closing %q: %v", v.Addr(), err)
 		}
@@ -126,88 +149,233 @@ func watchInstancesLoop(dir string, dst chan<- proxy.Conn, updates <-chan string
 }
 
 // WatchInstances handles the lifecycle of local sockets used for proxying
-// local connections.  Values received from the updates channel are
-// interpretted as a comma-separated list of instances.  The set of sockets in
-// 'dir' is the union of 'instances' and the most recent list from 'updates'.
-func WatchInstances(dir string, cfgs []instanceConfig, updates <-chan string, cl *http.Client) (<-chan proxy.Conn, error) {
+// local connections. Values received from the updates channel are
+// the complete list of listeners, listeners missing in the list are closed.
+// Initial listeners are opened from cfgs. onListen is called for every opened listener.
+func WatchInstances(cfgs []instanceConfig, updates <-chan []instanceConfig, onListen func(cfg instanceConfig, address string)) (<-chan proxy.Conn, error) {
 	ch := make(chan proxy.Conn, 1)
 
-	// Instances specified statically (e.g. as flags to the binary) will always
-	// be available. They are ignored if also returned by the GCE metadata since
-	// the socket will already be open.
-	staticInstances := make(map[string]net.Listener, len(cfgs))
+	open := make(map[string]net.Listener, len(cfgs))
 	for _, v := range cfgs {
 		l, err := listenInstance(ch, v)
 		if err != nil {
 			return nil, err
 		}
-		staticInstances[v.Instance] = l
+		open[listenerKey(v)] = l
+		onListen(v, l.Addr().String())
 	}
 
 	if updates != nil {
-		go watchInstancesLoop(dir, ch, updates, staticInstances, cl, cfgs)
+		go watchInstancesLoop(ch, updates, open, onListen)
 	}
 	return ch, nil
 }
 
-// CreateProxy creates a proxy tunnel to a given instance
-func CreateProxy(localPort int, instanceConnectionName CloudSQLInstance) {
-	dir := "" // Not much idea what that is
+// ProxyOptions configures the Cloud SQL proxy
+type ProxyOptions struct {
+	// IAMAuth enables IAM database authentication, database login is authorized by the user's Google identity
+	IAMAuth bool
+	// SocketDir enables Unix socket listener `<dir>/<connection name>`,
+	// Postgres socket is `<dir>/<connection name>/.s.PGSQL.5432`
+	SocketDir string
+	// IPTypes are tried in order to find the instance address, nil means DefaultIPTypes
+	IPTypes []IPType
+	// MaxConnections limits open connections, new connections wait in the queue. 0 means no limit.
+	MaxConnections uint64
+	// TermTimeout is how long to wait for open connections to close on TERM signal
+	TermTimeout time.Duration
+}
 
-	client := CreateHTTPAuthClient()
+// socketPath returns path of the instance's Unix socket in dir, as the upstream proxy does
+func socketPath(dir string, instance CloudSQLInstance) string {
+	if instance.Type == TypePostgres {
+		return filepath.Join(dir, instance.ConnectionName, ".s.PGSQL.5432")
+	}
+	return filepath.Join(dir, instance.ConnectionName)
+}
 
-	cfgs := []instanceConfig{
-		{Instance: instanceConnectionName.ConnectionName, Network: "tcp", Address: net.JoinHostPort("0.0.0.0", strconv.Itoa(localPort))},
+// instanceConfigs returns listeners of the instance, TCP unless local port is 0 and Unix socket if enabled
+func instanceConfigs(localPort int, instance CloudSQLInstance, options ProxyOptions) ([]instanceConfig, error) {
+	cfgs := []instanceConfig{}
+	if localPort != 0 {
+		cfgs = append(cfgs, instanceConfig{Instance: instance.ConnectionName, Type: instance.Type, Network: "tcp", Address: net.JoinHostPort(bindAddress, strconv.Itoa(localPort))})
 	}
+	if options.SocketDir != "" {
+		path := socketPath(options.SocketDir, instance)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			return nil, err
+		}
+		cfgs = append(cfgs, instanceConfig{Instance: instance.ConnectionName, Type: instance.Type, Network: "unix", Address: path})
+	}
+	return cfgs, nil
+}
 
-	// We only need to store connections in a ConnSet if FUSE is used; otherwise
-	// it is not efficient to do so.
-	var connset *proxy.ConnSet
+// Listener exposes the instance on the local port, 0 means Unix socket only
+type Listener struct {
+	Instance  CloudSQLInstance
+	LocalPort int
+}
 
-	// Initialize a source of new connections to Cloud SQL instances.
-	var connSrc <-chan proxy.Conn
-	updates := make(chan string)
+// ParseListeners parses comma separated connection names, each optionally followed by the local port,
+// e.g. `acme:europe-west1:primary=5432,acme:europe-west1:replica=5433`. Instance types are unknown.
+func ParseListeners(value string) ([]Listener, error) {
+	listeners := []Listener{}
+	if value == "" {
+		return listeners, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		name, localPort := strings.TrimSpace(item), 0
+		if i := strings.LastIndex(name, "="); i != -1 {
+			port, err := strconv.Atoi(name[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid local port of Cloud SQL instance %q: %v", item, err)
+			}
+			name, localPort = name[:i], port
+		}
+		listeners = append(listeners, Listener{Instance: CloudSQLInstance{ConnectionName: name, Type: TypeUnknown}, LocalPort: localPort})
+	}
+	return listeners, nil
+}
 
-	c, err := WatchInstances(dir, cfgs, updates, client)
+// FormatListeners formats listeners as accepted by ParseListeners
+func FormatListeners(listeners []Listener) string {
+	items := []string{}
+	for _, listener := range listeners {
+		item := listener.Instance.ConnectionName
+		if listener.LocalPort != 0 {
+			item += fmt.Sprintf("=%v", listener.LocalPort)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ",")
+}
+
+// listenersConfigs returns local listeners configuration of all instances
+func listenersConfigs(listeners []Listener, options ProxyOptions) ([]instanceConfig, error) {
+	cfgs := []instanceConfig{}
+	for _, listener := range listeners {
+		instanceCfgs, err := instanceConfigs(listener.LocalPort, listener.Instance, options)
+		if err != nil {
+			return nil, err
+		}
+		cfgs = append(cfgs, instanceCfgs...)
+	}
+	return cfgs, nil
+}
+
+// How often the instance is dialed until the listener is ready
+const probeInterval = 5 * time.Second
+
+// probe marks the listener ready once the instance accepts a connection.
+// Retries until it does or the listener is closed.
+func probe(client *proxy.Client, cfg instanceConfig, address string) {
+	for {
+		conn, err := client.Dial(cfg.Instance)
+		if err == nil {
+			conn.Close()
+			if health.Update(address, true, "") {
+				logging.Infof("Ready for new connections to %v (%v) on %v", cfg.Instance, cfg.Type, address)
+			}
+			return
+		}
+		logging.Errorf("Couldn't connect to %q: %v", cfg.Instance, err)
+		if !health.Update(address, false, err.Error()) {
+			return
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+// CreateProxy creates a proxy tunnel to given instances, all served by one proxy client.
+// Blocks until TERM signal and open connections are drained, returns error if they are not.
+func CreateProxy(listeners []Listener, options ProxyOptions) error {
+	client, err := CreateHTTPAuthClient()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	resolveTypes(ctx, client, listeners)
+	cancel()
+	cfgs, err := listenersConfigs(listeners, options)
+	if err != nil {
+		return err
 	}
-	connSrc = c
+
+	// We only need to store connections in a ConnSet if FUSE is used; otherwise
+	// it is not efficient to do so.
+	var connset *proxy.ConnSet
 
 	refreshCfgThrottle := time.Second
-	logging.Infof("Ready for new connections")
 
+	ipTypes := options.IPTypes
+	if len(ipTypes) == 0 {
+		ipTypes = DefaultIPTypes
+	}
 	host := ""
-	var maxConnections uint64 = 20
+	certOpts := certs.RemoteOpts{
+		APIBasePath:    host,
+		IgnoreRegion:   true,
+		UserAgent:      "goproxie",
+		IPAddrTypeOpts: certIPTypes(ipTypes),
+	}
+	var certSource proxy.CertSource = certs.NewCertSourceOpts(client, certOpts)
+	if options.IAMAuth {
+		iamCertSource, err := newIAMCertSource(client, certOpts)
+		if err != nil {
+			return err
+		}
+		certSource = iamCertSource
+		logging.Infof("IAM database authentication enabled")
+	}
+	if containsIPType(ipTypes, IPTypePSC) {
+		certSource = &pscCertSource{CertSource: certSource, client: client, ipTypes: ipTypes}
+	}
+	logging.Infof("Connecting via %v", FormatIPTypes(ipTypes))
+	// Limit is enforced by the queue, the client would refuse connections over it
 	proxyClient := &proxy.Client{
-		Port:           port,
-		MaxConnections: maxConnections,
-		Certs: certs.NewCertSourceOpts(client, certs.RemoteOpts{
-			APIBasePath:    host,
-			IgnoreRegion:   true,
-			UserAgent:      "goproxie",
-			IPAddrTypeOpts: []string{"PUBLIC", "PRIVATE"},
-		}),
+		Port:               port,
+		Certs:              &metricsCertSource{certSource},
+		Dialer:             dial,
 		Conns:              connset,
 		RefreshCfgThrottle: refreshCfgThrottle,
 	}
 
-	signals := make(chan os.Signal, 1)
+	// Initialize a source of new connections to Cloud SQL instances.
+	var connSrc <-chan proxy.Conn
+	updates := make(chan []instanceConfig)
+
+	c, err := WatchInstances(cfgs, updates, func(cfg instanceConfig, address string) {
+		go probe(proxyClient, cfg, address)
+	})
+	if err != nil {
+		return err
+	}
+	connSrc = c
+	go control(os.Stdin, client, listeners, options, updates)
+
+	signals := make(chan os.Signal, 2)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 
-	var termTimeout time.Duration = 0
+	termTimeout := options.TermTimeout
+	// Second signal does not wait for the drain
+	result := make(chan error, 2)
 	go func() {
 		<-signals
-		logging.Infof("Received TERM signal. Waiting up to %s before terminating.", termTimeout)
-
-		err := proxyClient.Shutdown(termTimeout)
-		if err == nil {
-			os.Exit(0)
+		logging.Infof("Received TERM signal. Waiting up to %s before terminating, send it again to terminate now.", termTimeout)
+		go func() {
+			<-signals
+			result <- fmt.Errorf("received second TERM signal, closing %d open connections", atomic.LoadUint64(&proxyClient.ConnectionsCounter))
+		}()
+		if err := drain(proxyClient, termTimeout); err != nil {
+			result <- fmt.Errorf("error during SIGTERM shutdown: %v", err)
+			return
 		}
-		logging.Errorf("Error during SIGTERM shutdown: %v", err)
-		os.Exit(2)
+		result <- nil
 	}()
 
-	proxyClient.Run(connSrc)
-	return
+	go proxyClient.Run(limitConnections(connSrc, options.MaxConnections))
+	err = <-result
+	stats.Default.WriteSummary(os.Stdout)
+	return err
 }
@@ -0,0 +1,75 @@
+package sqlproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstanceConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := CloudSQLInstance{ConnectionName: "acme:europe-west1:postgres", Type: TypePostgres}
+	result, err := instanceConfigs(5433, instance, ProxyOptions{SocketDir: dir})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedItems := []instanceConfig{
+		{Instance: instance.ConnectionName, Type: TypePostgres, Network: "tcp", Address: "127.0.0.1:5433"},
+		{Instance: instance.ConnectionName, Type: TypePostgres, Network: "unix", Address: filepath.Join(dir, "acme:europe-west1:postgres", ".s.PGSQL.5432")},
+	}
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	}
+	for i, expectedItem := range expectedItems {
+		if expectedItem != result[i] {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, result[i])
+		}
+	}
+}
+
+func TestInstanceConfigsSocketOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goproxie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	instance := CloudSQLInstance{ConnectionName: "acme:europe-west1:mysql", Type: TypeMySQL}
+	result, err := instanceConfigs(0, instance, ProxyOptions{SocketDir: dir})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if len(result) != 1 || result[0].Network != "unix" || result[0].Address != filepath.Join(dir, "acme:europe-west1:mysql") {
+		t.Errorf("Expected single Unix socket config, got `%v`", result)
+	}
+}
+
+func TestParseListeners(t *testing.T) {
+	value := "acme:europe-west1:primary=5432,acme:europe-west1:replica"
+	result, err := ParseListeners(value)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expectedItems := []Listener{
+		{Instance: CloudSQLInstance{ConnectionName: "acme:europe-west1:primary", Type: TypeUnknown}, LocalPort: 5432},
+		{Instance: CloudSQLInstance{ConnectionName: "acme:europe-west1:replica", Type: TypeUnknown}, LocalPort: 0},
+	}
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected len `%v` does not match result `%v`", len(expectedItems), len(result))
+	}
+	for i, expectedItem := range expectedItems {
+		if expectedItem.Instance.ConnectionName != result[i].Instance.ConnectionName || expectedItem.LocalPort != result[i].LocalPort {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, result[i])
+		}
+	}
+	if formatted := FormatListeners(result); formatted != value {
+		t.Errorf("Expected `%v` does not match result `%v`", value, formatted)
+	}
+	if _, err := ParseListeners("acme:europe-west1:primary=db"); err == nil {
+		t.Errorf("Expected error for invalid local port")
+	}
+}
@@ -0,0 +1,257 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// How many closed connections are kept for the summary, totals count all of them
+const maxClosed = 1000
+
+// Close reasons inferred from the connection traffic
+const (
+	ReasonClientClosed = "client closed"
+	ReasonRemoteClosed = "remote closed"
+	ReasonOpen         = "open"
+)
+
+// Connection is statistics of a single proxied connection.
+// In is sent by the local client, Out is received by it.
+type Connection struct {
+	Client   string
+	Target   string
+	BytesIn  uint64
+	BytesOut uint64
+	Opened   time.Time
+	Closed   time.Time
+	Reason   string
+}
+
+// Duration returns how long the connection lived, until now if it is open
+func (c *Connection) Duration() time.Duration {
+	if c.Closed.IsZero() {
+		return time.Since(c.Opened)
+	}
+	return c.Closed.Sub(c.Opened)
+}
+
+// Conn counts traffic of the wrapped connection and records it on close
+type Conn struct {
+	// Accessed atomically, first to be 64-bit aligned
+	bytesIn  uint64
+	bytesOut uint64
+	net.Conn
+	registry *Registry
+	stats    Connection
+	once     sync.Once
+}
+
+// SetReason sets the close reason unless one is already known
+func (c *Conn) SetReason(reason string) {
+	c.registry.mu.Lock()
+	defer c.registry.mu.Unlock()
+	if c.stats.Reason == "" {
+		c.stats.Reason = reason
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	if err == io.EOF {
+		c.SetReason(ReasonClientClosed)
+	} else if err != nil {
+		c.SetReason(err.Error())
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	if err != nil {
+		c.SetReason(err.Error())
+	}
+	return n, err
+}
+
+// Close closes the connection, the first close records it as closed
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		c.SetReason(ReasonRemoteClosed)
+		c.registry.record(c)
+	})
+	return c.Conn.Close()
+}
+
+// Totals aggregates connections of a target
+type Totals struct {
+	Open     int
+	Total    int
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Registry collects statistics of connections
+type Registry struct {
+	mu       sync.Mutex
+	open     map[*Conn]bool
+	closed   []Connection
+	total    int
+	bytesIn  uint64
+	bytesOut uint64
+	// Closed connections by target, kept in full unlike closed
+	targets map[string]*Totals
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{open: map[*Conn]bool{}, targets: map[string]*Totals{}}
+}
+
+// Default registry of the process
+var Default = NewRegistry()
+
+// Track wraps the accepted connection to the target (pod, Cloud SQL instance...) of the default registry
+func Track(conn net.Conn, target string) *Conn {
+	return Default.Track(conn, target)
+}
+
+// Track wraps the accepted connection to the target (pod, Cloud SQL instance...)
+func (r *Registry) Track(conn net.Conn, target string) *Conn {
+	c := &Conn{
+		Conn:     conn,
+		registry: r,
+		stats:    Connection{Client: conn.RemoteAddr().String(), Target: target, Opened: time.Now()},
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.open[c] = true
+	r.total++
+	return c
+}
+
+// record moves the connection from open to closed ones
+func (r *Registry) record(c *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.open, c)
+	c.stats.Closed = time.Now()
+	stats := c.snapshot()
+	r.bytesIn += stats.BytesIn
+	r.bytesOut += stats.BytesOut
+	totals, ok := r.targets[stats.Target]
+	if !ok {
+		totals = &Totals{}
+		r.targets[stats.Target] = totals
+	}
+	totals.Total++
+	totals.BytesIn += stats.BytesIn
+	totals.BytesOut += stats.BytesOut
+	r.closed = append(r.closed, stats)
+	if len(r.closed) > maxClosed {
+		r.closed = r.closed[len(r.closed)-maxClosed:]
+	}
+}
+
+// snapshot copies the statistics, registry lock must be held
+func (c *Conn) snapshot() Connection {
+	stats := c.stats
+	stats.BytesIn = atomic.LoadUint64(&c.bytesIn)
+	stats.BytesOut = atomic.LoadUint64(&c.bytesOut)
+	if stats.Closed.IsZero() {
+		stats.Reason = ReasonOpen
+	}
+	return stats
+}
+
+// Connections returns closed connections followed by the open ones
+func (r *Registry) Connections() []Connection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	connections := append([]Connection{}, r.closed...)
+	for c := range r.open {
+		connections = append(connections, c.snapshot())
+	}
+	return connections
+}
+
+// Totals returns totals of all connections by target
+func (r *Registry) Totals() map[string]Totals {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := map[string]Totals{}
+	for target, totals := range r.targets {
+		result[target] = *totals
+	}
+	for c := range r.open {
+		totals := result[c.stats.Target]
+		totals.Open++
+		totals.Total++
+		totals.BytesIn += atomic.LoadUint64(&c.bytesIn)
+		totals.BytesOut += atomic.LoadUint64(&c.bytesOut)
+		result[c.stats.Target] = totals
+	}
+	return result
+}
+
+// Line returns one line overview, e.g. `Connections: 1 open, 12 total, 1.2 MiB in, 30.5 MiB out`
+func (r *Registry) Line() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bytesIn, bytesOut := r.bytesIn, r.bytesOut
+	for c := range r.open {
+		bytesIn += atomic.LoadUint64(&c.bytesIn)
+		bytesOut += atomic.LoadUint64(&c.bytesOut)
+	}
+	return fmt.Sprintf("Connections: %v open, %v total, %v in, %v out", len(r.open), r.total, formatBytes(bytesIn), formatBytes(bytesOut))
+}
+
+// WriteSummary writes table of all connections
+func (r *Registry) WriteSummary(out io.Writer) {
+	connections := r.Connections()
+	if len(connections) == 0 {
+		fmt.Fprintln(out, "No connections")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tTARGET\tIN\tOUT\tDURATION\tREASON")
+	for _, c := range connections {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", c.Client, c.Target, formatBytes(c.BytesIn), formatBytes(c.BytesOut), c.Duration().Round(time.Millisecond), c.Reason)
+	}
+	w.Flush()
+	fmt.Fprintln(out, r.Line())
+}
+
+// LogEvery logs the overview line periodically, until stop is closed
+func (r *Registry) LogEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Print(r.Line())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTrack(t *testing.T) {
+	registry := NewRegistry()
+	client, local := net.Pipe()
+	conn := registry.Track(local, "api-74bf544f8b-lzc5b:8080")
+	go func() {
+		client.Write([]byte("ping"))
+		buffer := make([]byte, 8)
+		client.Read(buffer)
+		client.Close()
+	}()
+	buffer := make([]byte, 4)
+	conn.Read(buffer)
+	conn.Write([]byte("pong!"))
+	// Client has closed the pipe
+	conn.Read(buffer)
+	conn.Close()
+	conn.Close()
+
+	connections := registry.Connections()
+	if len(connections) != 1 {
+		t.Fatalf("Expected `%v` does not match result `%v`", 1, len(connections))
+	}
+	c := connections[0]
+	if c.BytesIn != 4 || c.BytesOut != 5 {
+		t.Errorf("Expected `%v` in and `%v` out, got `%v` and `%v`", 4, 5, c.BytesIn, c.BytesOut)
+	}
+	if c.Reason != ReasonClientClosed {
+		t.Errorf("Expected `%v` does not match result `%v`", ReasonClientClosed, c.Reason)
+	}
+	expected := "Connections: 0 open, 1 total, 4 B in, 5 B out"
+	if registry.Line() != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, registry.Line())
+	}
+	out := bytes.Buffer{}
+	registry.WriteSummary(&out)
+	if !strings.Contains(out.String(), "api-74bf544f8b-lzc5b:8080") {
+		t.Errorf("Expected summary to contain the target, got `%v`", out.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{512: "512 B", 1536: "1.5 KiB", 3 * 1024 * 1024: "3.0 MiB"}
+	for n, expected := range cases {
+		if result := formatBytes(n); result != expected {
+			t.Errorf("Expected `%v` does not match result `%v`", expected, result)
+		}
+	}
+}
@@ -1,32 +1,47 @@
 package store
 
 import (
-	"log"
 	"os"
 	"os/user"
 	"path"
 
+	"github.com/AckeeCZ/goproxie/internal/errs"
 	"github.com/spf13/viper"
 )
 
 // MaxAppendLength defines max stored commands threshold
 const MaxAppendLength = 100
 
-// Initialize reads the configuration from config file.
-// File is created if not present.
-func Initialize() {
+// Dir returns the goproxie configuration directory.
+// Uses `$XDG_CONFIG_HOME/goproxie` or `~/.config/goproxie`, home directory is taken from `$HOME`
+// when the current user can't be looked up. Empty if neither is known, Initialize reports the error.
+func Dir() string {
+	dir, _ := dir()
+	return dir
+}
+
+func dir() (string, error) {
+	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
+	if xdgConfigHome != "" {
+		return path.Join(xdgConfigHome, "goproxie"), nil
+	}
+	home := ""
 	user, err := user.Current()
-	if err != nil {
-		log.Fatal(err)
+	if err == nil {
+		home = user.HomeDir
+	} else if home, err = os.UserHomeDir(); err != nil {
+		return "", errs.New(errs.NotFound, "configuration directory", err)
 	}
+	return path.Join(home, ".config", "goproxie"), nil
+}
+
+// Initialize reads the configuration from config file.
+// File is created if not present.
+func Initialize() error {
 	viper.SetConfigType("json")
-	configPath := ""
-	// Use `$XDG_CONFIG_HOME` or `~/.config` as config dir
-	xdgConfigHome := os.Getenv("XDG_CONFIG_HOME")
-	if xdgConfigHome != "" {
-		configPath = path.Join(xdgConfigHome, "goproxie")
-	} else {
-		configPath = path.Join(user.HomeDir, ".config", "goproxie")
+	configPath, err := dir()
+	if err != nil {
+		return err
 	}
 	configFile := "store"
 
@@ -41,10 +56,10 @@ func Initialize() {
 	if err != nil {
 		// If file already exists, its fine
 		if _, ok := err.(viper.ConfigFileAlreadyExistsError); !ok {
-			log.Fatal(err)
+			return errs.Classify("store "+configPath, "", err)
 		}
 	}
-	viper.ReadInConfig()
+	return viper.ReadInConfig()
 }
 
 // Set configuration key-value pair.
@@ -59,6 +74,11 @@ func Get(key string) interface{} {
 	return viper.Get(key)
 }
 
+// UnmarshalKey decodes configuration value for key into rawVal.
+func UnmarshalKey(key string, rawVal interface{}) error {
+	return viper.UnmarshalKey(key, rawVal)
+}
+
 // Append value to given key. Expects the value to be an array or not set.
 // Acts as FIFO if length should be greater than MaxAppendLength, the first value
 // appended is the first to go.
@@ -0,0 +1,191 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// State of a tunnel process
+type State string
+
+const (
+	// StateStarting tunnel is resolving its target and opening the connection
+	StateStarting State = "starting"
+	// StateReady tunnel accepts local connections
+	StateReady State = "ready"
+	// StateReconnecting tunnel lost its target and tries to connect again
+	StateReconnecting State = "reconnecting"
+	// StateFailed tunnel process exited with an error
+	StateFailed State = "failed"
+	// StateStopped tunnel process exited successfully
+	StateStopped State = "stopped"
+)
+
+// Output markers of the proxy backends the tunnel state is derived from
+var stateMarkers = []struct {
+	text  string
+	state State
+}{
+	// kubectl port-forward
+	{"Forwarding from", StateReady},
+	// Cloud SQL proxy
+	{"Ready for new connections", StateReady},
+	{"lost connection to pod", StateReconnecting},
+	{"Reconnecting", StateReconnecting},
+}
+
+// Output lines announcing local endpoints of pod forwards and Cloud SQL instances
+var (
+	forwardingPattern = regexp.MustCompile(`Forwarding from (\S+) -> \d+`)
+	sqlReadyPattern   = regexp.MustCompile(`Ready for new connections to (\S+) \((\w+)\) on (.+)$`)
+)
+
+// Endpoint is a local address the tunnel accepts connections on
+type Endpoint struct {
+	// Address is host:port or Unix socket path
+	Address string
+	// Instance is the Cloud SQL connection name, empty for pods
+	Instance string
+	// DatabaseType is the Cloud SQL instance type, e.g. POSTGRES
+	DatabaseType string
+}
+
+// parseEndpoint returns the endpoint announced by the output line
+func parseEndpoint(line string) (Endpoint, bool) {
+	if match := forwardingPattern.FindStringSubmatch(line); match != nil {
+		return Endpoint{Address: match[1]}, true
+	}
+	if match := sqlReadyPattern.FindStringSubmatch(line); match != nil {
+		return Endpoint{Address: match[3], Instance: match[1], DatabaseType: match[2]}, true
+	}
+	return Endpoint{}, false
+}
+
+// Process is a goproxie tunnel running as a non-interactive child process.
+type Process struct {
+	Name string
+	Args []string
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+
+	mutex     sync.Mutex
+	state     State
+	lastLine  string
+	endpoints []Endpoint
+}
+
+// Start executes goproxie binary with given arguments, tunnels are never saved to history.
+// Every output line of the child is written to out prefixed with the tunnel name.
+func Start(bin string, name string, args []string, out io.Writer) (*Process, error) {
+	return StartWithProcAttr(bin, name, args, out, nil)
+}
+
+// StartWithProcAttr is Start with OS specific process attributes, e.g. to not receive signals of the terminal.
+func StartWithProcAttr(bin string, name string, args []string, out io.Writer, attr *syscall.SysProcAttr) (*Process, error) {
+	p := &Process{Name: name, Args: args, done: make(chan struct{}), state: StateStarting}
+	p.cmd = exec.Command(bin, append(append([]string{}, args...), "-no-save")...)
+	p.cmd.SysProcAttr = attr
+	reader, writer := io.Pipe()
+	p.cmd.Stdout = writer
+	p.cmd.Stderr = writer
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.scanLines(reader, out, fmt.Sprintf("[%v] ", name))
+	}()
+	go func() {
+		err := p.cmd.Wait()
+		writer.Close()
+		wg.Wait()
+		p.mutex.Lock()
+		p.err = err
+		if err != nil {
+			p.state = StateFailed
+		} else {
+			p.state = StateStopped
+		}
+		p.mutex.Unlock()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// scanLines copies lines from r to w with given prefix and updates the state by them
+func (p *Process) scanLines(r io.Reader, w io.Writer, prefix string) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintf(w, "%v%v\n", prefix, line)
+		p.mutex.Lock()
+		p.lastLine = line
+		for _, marker := range stateMarkers {
+			if strings.Contains(line, marker.text) {
+				p.state = marker.state
+			}
+		}
+		if endpoint, ok := parseEndpoint(line); ok {
+			p.endpoints = append(p.endpoints, endpoint)
+		}
+		p.mutex.Unlock()
+	}
+	// Drain the rest (e.g. too long line) so the child never blocks on write
+	io.Copy(ioutil.Discard, r)
+}
+
+// Stop asks the tunnel to terminate.
+// Interrupt is not available on Windows, process is killed there instead.
+func (p *Process) Stop() {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		p.cmd.Process.Kill()
+	}
+}
+
+// State returns current state of the tunnel.
+func (p *Process) State() State {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state
+}
+
+// LastLine returns the last output line of the tunnel, usually the failure reason.
+func (p *Process) LastLine() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lastLine
+}
+
+// Endpoints returns local endpoints announced by the tunnel so far, in order.
+func (p *Process) Endpoints() []Endpoint {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]Endpoint{}, p.endpoints...)
+}
+
+// Pid returns process ID of the tunnel.
+func (p *Process) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+// Done is closed when the tunnel process exits.
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the tunnel process exits and returns its exit error.
+func (p *Process) Wait() error {
+	<-p.done
+	return p.err
+}
@@ -0,0 +1,23 @@
+package tunnel
+
+import "testing"
+
+func TestParseEndpoint(t *testing.T) {
+	items := []struct {
+		line     string
+		expected Endpoint
+		ok       bool
+	}{
+		{"Forwarding from 127.0.0.1:8080 -> 80", Endpoint{Address: "127.0.0.1:8080"}, true},
+		{"Forwarding from [::1]:8080 -> 80", Endpoint{Address: "[::1]:8080"}, true},
+		{"2020/06/01 10:00:00 Ready for new connections to acme:europe-west1:db (POSTGRES) on 127.0.0.1:5432", Endpoint{Address: "127.0.0.1:5432", Instance: "acme:europe-west1:db", DatabaseType: "POSTGRES"}, true},
+		{"2020/06/01 10:00:00 Ready for new connections to acme:europe-west1:db (UNKNOWN) on /tmp/cloud sql/acme:europe-west1:db", Endpoint{Address: "/tmp/cloud sql/acme:europe-west1:db", Instance: "acme:europe-west1:db", DatabaseType: "UNKNOWN"}, true},
+		{"Handling connection for 8080", Endpoint{}, false},
+	}
+	for _, item := range items {
+		result, ok := parseEndpoint(item.line)
+		if result != item.expected || ok != item.ok {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+}
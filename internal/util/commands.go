@@ -1,30 +1,53 @@
 package util
 
 import (
-	"log"
+	"bytes"
+	"context"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
 )
 
-// RunCommand executes given command with args, automatically exits program on error.
-func RunCommand(command string, args ...string) string {
-	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	out, err := cmd.Output()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return string(out)
+// RunCommand executes given command with args and returns its output.
+// Stderr is not forwarded (e.g. debug messages of gcloud), it is attached to the returned error
+// classified by errs.Classify. The command is killed when ctx is done, the error is then
+// of errs.Timeout or errs.Canceled kind.
+func RunCommand(ctx context.Context, command string, args ...string) (string, error) {
+	return RunCommandWithEnv(ctx, nil, command, args...)
 }
 
-// RunSilentCommand is same as RunCommand but does not forward stderr.
-// TODO: Remove and refactor RunCommand to print stderr only when err happens
-// due to gcloud printing to stderr it's debug messages
-func RunSilentCommand(command string, args ...string) string {
+// RunCommandWithEnv is same as RunCommand, env is appended to the current environment.
+func RunCommandWithEnv(ctx context.Context, env []string, command string, args ...string) (string, error) {
+	op := strings.Join(append([]string{filepath.Base(command)}, args...), " ")
 	cmd := exec.Command(command, args...)
-	out, err := cmd.Output()
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = commandProcAttr()
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return "", errs.Classify(op, "", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Children of wrapper scripts (e.g. gcloud's python) would keep the output open
+		killCommand(cmd)
+		<-done
+		// Killed by the context, exit status is meaningless
+		err = ctx.Err()
+	}
 	if err != nil {
-		log.Fatal(err)
+		return stdout.String(), errs.Classify(op, stderr.String(), err)
 	}
-	return string(out)
+	return stdout.String(), nil
 }
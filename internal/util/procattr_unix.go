@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// commandProcAttr starts the command in its own process group, so Ctrl+C in the terminal
+// does not reach it and it is killed by the canceled context instead.
+func commandProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killCommand kills the command's process group, including processes it started
+func killCommand(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
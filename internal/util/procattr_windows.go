@@ -0,0 +1,17 @@
+package util
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// commandProcAttr starts the command in a new process group, so Ctrl+C in the console
+// does not reach it and it is killed by the canceled context instead.
+func commandProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killCommand kills the command
+func killCommand(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
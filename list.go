@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AckeeCZ/goproxie/internal/errs"
+	"github.com/AckeeCZ/goproxie/internal/gcloud"
+	"github.com/AckeeCZ/goproxie/internal/kubectl"
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+	"sigs.k8s.io/yaml"
+)
+
+const listUsage = "Usage: goproxie list projects|clusters|namespaces|pods|sql-instances [-o table|json|yaml] [-project=...] [-cluster=...] [-namespace=...]"
+
+// Output formats of `list`
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// listing is a result of `list`, items are serialized to JSON and YAML, rows are the table
+type listing struct {
+	items   interface{}
+	columns []string
+	rows    [][]string
+}
+
+type listedProject struct {
+	Name string `json:"name"`
+}
+
+type listedCluster struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+}
+
+type listedNamespace struct {
+	Name string `json:"name"`
+}
+
+type listedPod struct {
+	Name       string            `json:"name"`
+	App        string            `json:"app"`
+	Containers []string          `json:"containers"`
+	Ports      []int             `json:"ports"`
+	Images     []string          `json:"images"`
+	Labels     map[string]string `json:"labels"`
+	Phase      string            `json:"phase"`
+	Ready      bool              `json:"ready"`
+	Restarts   int               `json:"restarts"`
+	Node       string            `json:"node"`
+	StartTime  string            `json:"startTime,omitempty"`
+}
+
+type listedSQLInstance struct {
+	ConnectionName string   `json:"connectionName"`
+	Type           string   `json:"type"`
+	DefaultPort    int      `json:"defaultPort"`
+	IPTypes        []string `json:"ipTypes"`
+}
+
+// runList handles `goproxie list <resource>`, prints what the wizard offers without prompting.
+// Parent resources are given by options and must match exactly.
+func runList(resource string) error {
+	format := *flags.output
+	if format != outputTable && format != outputJSON && format != outputYAML {
+		fmt.Println(listUsage)
+		os.Exit(2)
+	}
+	var result listing
+	var err error
+	ctx, cancel := callContext()
+	defer cancel()
+	switch resource {
+	case "projects":
+		result, err = listProjects(ctx)
+	case "clusters":
+		result, err = listClusters(ctx)
+	case "namespaces":
+		result, err = listNamespaces(ctx)
+	case "pods":
+		result, err = listPods(ctx)
+	case "sql-instances":
+		result, err = listSQLInstances(ctx)
+	default:
+		fmt.Println(listUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+	return writeListing(os.Stdout, format, result)
+}
+
+// requireFlags returns error naming the first of the options that is not set
+func requireFlags(names ...string) error {
+	values := map[string]string{"project": *flags.project, "cluster": *flags.cluster, "namespace": *flags.namespace}
+	for _, name := range names {
+		if values[name] == "" {
+			return fmt.Errorf("-%v is required", name)
+		}
+	}
+	return nil
+}
+
+func listProjects(ctx context.Context) (listing, error) {
+	projects, err := gcloudProjectsList(ctx)
+	if err != nil {
+		return listing{}, err
+	}
+	result := listing{columns: []string{"NAME"}}
+	items := []listedProject{}
+	for _, project := range projects {
+		items = append(items, listedProject{Name: project})
+		result.rows = append(result.rows, []string{project})
+	}
+	result.items = items
+	return result, nil
+}
+
+func listClusters(ctx context.Context) (listing, error) {
+	if err := requireFlags("project"); err != nil {
+		return listing{}, err
+	}
+	clusters, err := gcloudContainerClustersList(ctx, *flags.project)
+	if err != nil {
+		return listing{}, err
+	}
+	result := listing{columns: []string{"NAME", "LOCATION"}}
+	items := []listedCluster{}
+	for _, cluster := range clusters {
+		items = append(items, listedCluster{Name: cluster.Name, Location: cluster.Location})
+		result.rows = append(result.rows, []string{cluster.Name, cluster.Location})
+	}
+	result.items = items
+	return result, nil
+}
+
+// useCluster fetches credentials of the cluster given by -project and -cluster for kubectl
+func useCluster(ctx context.Context) error {
+	if err := requireFlags("project", "cluster"); err != nil {
+		return err
+	}
+	clusters, err := gcloudContainerClustersList(ctx, *flags.project)
+	if err != nil {
+		return err
+	}
+	var cluster *gcloud.Cluster
+	for _, c := range clusters {
+		if c.Name == *flags.cluster {
+			cluster = c
+		}
+	}
+	if cluster == nil {
+		return errs.New(errs.NotFound, "Cluster "+*flags.cluster, fmt.Errorf("not found in project %v", *flags.project))
+	}
+	kubeconfig, err := clusterKubeconfig(*flags.project, cluster)
+	if err != nil {
+		return err
+	}
+	if err := gcloudGetClusterCredentials(ctx, *flags.project, cluster, kubeconfig); err != nil {
+		return err
+	}
+	kubectl.SetKubeconfig(kubeconfig)
+	return nil
+}
+
+func listNamespaces(ctx context.Context) (listing, error) {
+	if err := useCluster(ctx); err != nil {
+		return listing{}, err
+	}
+	namespaces, err := kubectlNamespacesList(ctx)
+	if err != nil {
+		return listing{}, err
+	}
+	result := listing{columns: []string{"NAME"}}
+	items := []listedNamespace{}
+	for _, namespace := range namespaces {
+		items = append(items, listedNamespace{Name: namespace})
+		result.rows = append(result.rows, []string{namespace})
+	}
+	result.items = items
+	return result, nil
+}
+
+func listPods(ctx context.Context) (listing, error) {
+	if err := requireFlags("project", "cluster", "namespace"); err != nil {
+		return listing{}, err
+	}
+	if err := useCluster(ctx); err != nil {
+		return listing{}, err
+	}
+	pods, err := kubectlPodsList(ctx, *flags.namespace, *flags.selector)
+	if err != nil {
+		return listing{}, err
+	}
+	result := listing{columns: []string{"NAME", "APP", "CONTAINERS", "PORTS", "IMAGES"}}
+	items := []listedPod{}
+	for _, pod := range pods {
+		item := listedPod{Name: pod.Name, App: pod.AppLabel, Containers: pod.Containers, Ports: pod.ContainerPorts, Images: pod.Images,
+			Labels: pod.Labels, Phase: pod.Phase, Ready: pod.Ready, Restarts: pod.Restarts, Node: pod.Node}
+		if !pod.StartTime.IsZero() {
+			item.StartTime = pod.StartTime.UTC().Format(time.RFC3339)
+		}
+		items = append(items, item)
+		ports := []string{}
+		for _, port := range pod.ContainerPorts {
+			ports = append(ports, strconv.Itoa(port))
+		}
+		result.rows = append(result.rows, []string{pod.Name, pod.AppLabel, strings.Join(pod.Containers, ","), strings.Join(ports, ","), strings.Join(pod.Images, ",")})
+	}
+	result.items = items
+	return result, nil
+}
+
+func listSQLInstances(ctx context.Context) (listing, error) {
+	if err := requireFlags("project"); err != nil {
+		return listing{}, err
+	}
+	instances, err := sqlproxy.GetInstancesList(ctx, []string{*flags.project})
+	if err != nil {
+		return listing{}, err
+	}
+	result := listing{columns: []string{"CONNECTION NAME", "TYPE", "DEFAULT PORT", "IP TYPES"}}
+	items := []listedSQLInstance{}
+	for _, instance := range instances {
+		ipTypes := strings.Split(sqlproxy.FormatIPTypes(instance.IPTypes), ",")
+		if len(instance.IPTypes) == 0 {
+			ipTypes = []string{}
+		}
+		items = append(items, listedSQLInstance{ConnectionName: instance.ConnectionName, Type: string(instance.Type), DefaultPort: instance.DefaultPort, IPTypes: ipTypes})
+		result.rows = append(result.rows, []string{instance.ConnectionName, string(instance.Type), strconv.Itoa(instance.DefaultPort), sqlproxy.FormatIPTypes(instance.IPTypes)})
+	}
+	result.items = items
+	return result, nil
+}
+
+// writeListing writes the listing as table, JSON or YAML
+func writeListing(out io.Writer, format string, result listing) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result.items)
+	case outputYAML:
+		data, err := yaml.Marshal(result.items)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(result.columns, "\t"))
+		for _, row := range result.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+}
@@ -1,23 +1,38 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/AckeeCZ/goproxie/internal/errs"
 	"github.com/AckeeCZ/goproxie/internal/gcloud"
+	"github.com/AckeeCZ/goproxie/internal/health"
 	"github.com/AckeeCZ/goproxie/internal/history"
 	"github.com/AckeeCZ/goproxie/internal/kubectl"
+	"github.com/AckeeCZ/goproxie/internal/metrics"
+	"github.com/AckeeCZ/goproxie/internal/ports"
 	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+	"github.com/AckeeCZ/goproxie/internal/stats"
 	"github.com/AckeeCZ/goproxie/internal/store"
 	"github.com/AckeeCZ/goproxie/internal/version"
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
 	"github.com/briandowns/spinner"
+	"github.com/mattn/go-isatty"
 )
 
 var gcloudProjectsList = gcloud.ProjectsList
@@ -27,6 +42,8 @@ var gcloudGetClusterCredentials = gcloud.GetClusterCredentials
 var gcloudSetProject = gcloud.SetDefaultProject
 var kubectlNamespacesList = kubectl.NamespacesList
 var kubectlPortForward = kubectl.PortForward
+var kubectlTargetsList = kubectl.TargetsList
+var kubectlTargetPortForward = kubectl.TargetPortForward
 
 func initializationCheck() {
 	// TODO
@@ -34,7 +51,7 @@ func initializationCheck() {
 
 var readProxyType = func() ProxyType {
 	proxyType := ""
-	proxyTypes := []string{string(ProxyTypePod), string(ProxyTypeSQL)}
+	proxyTypes := []string{string(ProxyTypePod), string(ProxyTypeService), string(ProxyTypeDeployment), string(ProxyTypeStatefulSet), string(ProxyTypeSQL)}
 
 	desiredProxyType := *flags.proxyType
 	if *flags.sqlInstance != "" {
@@ -56,19 +73,71 @@ var readProxyType = func() ProxyType {
 	return ProxyType(proxyType)
 }
 
-// ProxyType is one of Pod, CloudSQL
+// ProxyType is one of Pod, Service, Deployment, StatefulSet, CloudSQL
 type ProxyType string
 
 const (
 	// ProxyTypePod Pod proxy type
 	ProxyTypePod ProxyType = "POD"
+	// ProxyTypeService K8S Service proxy type
+	ProxyTypeService ProxyType = "SERVICE"
+	// ProxyTypeDeployment K8S Deployment proxy type
+	ProxyTypeDeployment ProxyType = "DEPLOYMENT"
+	// ProxyTypeStatefulSet K8S StatefulSet proxy type
+	ProxyTypeStatefulSet ProxyType = "STATEFULSET"
 	// ProxyTypeSQL CloudSQL proxy type
 	ProxyTypeSQL ProxyType = "CLOUD_SQL"
 )
 
+// targetKinds maps K8S workload proxy types to kubectl resource kinds
+var targetKinds = map[ProxyType]kubectl.TargetKind{
+	ProxyTypeService:     kubectl.KindService,
+	ProxyTypeDeployment:  kubectl.KindDeployment,
+	ProxyTypeStatefulSet: kubectl.KindStatefulSet,
+}
+
 // 💡 Spinner!
 var loading = spinner.New(spinner.CharSets[21], 100*time.Millisecond)
 
+func init() {
+	// Don't animate when output is not a terminal, e.g. tunnels of `up`
+	if !isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd()) {
+		loading.Writer = ioutil.Discard
+	}
+}
+
+// interruptContext returns context canceled by SIGINT/SIGTERM, port-forwards run until then
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// callContext returns context of external calls made while loading, limited by -timeout and canceled by Ctrl+C,
+// so a hung gcloud or kubectl does not leave the spinner running forever
+func callContext() (context.Context, context.CancelFunc) {
+	ctx, stop := interruptContext()
+	if *flags.timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, *flags.timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
 func loadingStart(suffix string) {
 	loading.Start()
 	loading.Suffix = fmt.Sprintf(" %v", suffix)
@@ -85,56 +154,120 @@ type Flags struct {
 	cluster    *string
 	namespace  *string
 	pod        *string
+	target     *string
 	localPort  *string
 	remotePort *string
 	/** Dont save to history */
 	noSave      *bool
 	sqlInstance *string
+	name        *string
+	/** Use and modify user's default kubeconfig */
+	globalKubeconfig *bool
+	bindAddress      *string
+	sqlIAMAuth       *bool
+	sqlSocketDir     *string
+	sqlIPType        *string
+	sqlMaxConns      *uint64
+	sqlTermTimeout   *time.Duration
+	forwarder        *string
+	stats            *time.Duration
+	metricsAddr      *string
+	healthAddr       *string
+	waitReady        *bool
+	waitTimeout      *time.Duration
+	sqlUser          *string
+	sqlDatabase      *string
+	output           *string
+	timeout          *time.Duration
+	includeUnready   *bool
+	selector         *string
+	/** Explicitly set options as -<name>=<value>, e.g. to pass them to a tunnel started by the daemon */
+	setArgs []string
 }
 
 var flags = &Flags{}
 
 type selectFieldOption struct {
 	title string
-	value interface{}
+	// description is shown next to the title in the interactive prompt only
+	description string
+	value       interface{}
+	// manualOnly option is offered in the interactive prompt, but never selected automatically
+	manualOnly bool
+}
+
+// label returns the option text displayed in the interactive prompt
+func (option selectFieldOption) label() string {
+	if option.description == "" {
+		return option.title
+	}
+	return fmt.Sprintf("%v (%v)", option.title, option.description)
 }
+
 type selectField struct {
 	titleChoose  string
 	titleLoading string
 	valueTitle   string
-	getOptions   func() []selectFieldOption
+	getOptions   func(ctx context.Context) ([]selectFieldOption, error)
+	// manualReason explains why manualOnly options are not selected automatically
+	manualReason string
+	// autoSelect picks the first option that is not manualOnly without prompting, unless valueTitle is set
+	autoSelect bool
 }
 
-func promptSelection(sel selectField) interface{} {
+func promptSelection(sel selectField) (interface{}, error) {
 	// Load options
 	loadingStart(fmt.Sprintf("Loading %v", sel.titleLoading))
-	options := sel.getOptions()
+	ctx, cancel := callContext()
+	options, err := sel.getOptions(ctx)
+	cancel()
 	loadingStop()
+	if err != nil {
+		return nil, err
+	}
 	// Shortcircuit selection if theres is only one option
-	if len(options) == 1 {
+	if len(options) == 1 && !options[0].manualOnly {
 		fmt.Printf("%v: %v\n", sel.titleChoose, options[0].title)
-		return options[0].value
+		return options[0].value, nil
 	}
 	// Serialize options to strings
 	optionTitles := []string{}
+	manualTitles := []string{}
 	for _, option := range options {
-		optionTitles = append(optionTitles, option.title)
+		if option.manualOnly {
+			manualTitles = append(manualTitles, option.title)
+		} else {
+			optionTitles = append(optionTitles, option.title)
+		}
 	}
 	pickedTitle := ""
-	if sel.valueTitle != "" {
+	if sel.valueTitle != "" || sel.autoSelect {
 		// Apply selection, if set
 		filtered := filterStrings(optionTitles, sel.valueTitle)
 		if len(filtered) > 0 {
 			pickedTitle = filtered[0]
 			fmt.Printf("%v: %v\n", sel.titleChoose, pickedTitle)
+		} else if manual := filterStrings(manualTitles, sel.valueTitle); len(manual) > 0 {
+			return nil, errs.New(errs.NotFound, strings.TrimSpace(fmt.Sprintf("%v %v", sel.titleChoose, sel.valueTitle)),
+				fmt.Errorf("%v %v", strings.Join(manualLabels(options, manual), ", "), sel.manualReason))
 		}
 	} else {
 		// Pick from Input otherwise
+		optionLabels := []string{}
+		for _, option := range options {
+			optionLabels = append(optionLabels, option.label())
+		}
+		pickedLabel := ""
 		prompt := &survey.Select{
 			Message: fmt.Sprintf("Choose %v:", sel.titleChoose),
-			Options: optionTitles,
+			Options: optionLabels,
+		}
+		survey.AskOne(prompt, &pickedLabel)
+		for _, option := range options {
+			if option.label() == pickedLabel {
+				pickedTitle = option.title
+			}
 		}
-		survey.AskOne(prompt, &pickedTitle)
 	}
 	var pickedOption selectFieldOption
 	// Reverse-search Option by picked title
@@ -143,41 +276,58 @@ func promptSelection(sel selectField) interface{} {
 			pickedOption = option
 		}
 	}
-	return pickedOption.value
+	return pickedOption.value, nil
+}
+
+// manualLabels returns labels of the options with given titles
+func manualLabels(options []selectFieldOption, titles []string) []string {
+	labels := []string{}
+	for _, title := range titles {
+		for _, option := range options {
+			if option.title == title {
+				labels = append(labels, option.label())
+			}
+		}
+	}
+	return labels
 }
 
-func readProjectID() (projectID string) {
+func readProjectID() (projectID string, err error) {
 	if isBlindCloudSQLConnection() {
-		return ""
+		return "", nil
 	}
-	// Cannot return directly, I have to accept both return values to avoid crash 🤷
-	// https://gist.github.com/smoliji/f72fe94b028125a22efa53a430ba007a
-	projectID, _ = promptSelection(selectField{
+	value, err := promptSelection(selectField{
 		titleLoading: "GCP Projects",
 		titleChoose:  "GCP Project",
-		getOptions: func() (options []selectFieldOption) {
-			for _, project := range gcloudProjectsList() {
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			projects, err := gcloudProjectsList(ctx)
+			for _, project := range projects {
 				options = append(options, selectFieldOption{title: project, value: project})
 			}
 			return
 		},
 		valueTitle: *flags.project,
-	}).(string)
+	})
+	// Cannot return directly, I have to accept both return values to avoid crash 🤷
+	// https://gist.github.com/smoliji/f72fe94b028125a22efa53a430ba007a
+	projectID, _ = value.(string)
 	return
 }
 
-func readCluster(projectID string) (cluster *gcloud.Cluster) {
-	cluster, _ = promptSelection(selectField{
+func readCluster(projectID string) (cluster *gcloud.Cluster, err error) {
+	value, err := promptSelection(selectField{
 		titleLoading: "Clusters",
 		titleChoose:  "Cluster",
-		getOptions: func() (options []selectFieldOption) {
-			for _, cluster := range gcloudContainerClustersList(projectID) {
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			clusters, err := gcloudContainerClustersList(ctx, projectID)
+			for _, cluster := range clusters {
 				options = append(options, selectFieldOption{title: cluster.Name, value: cluster})
 			}
 			return
 		},
 		valueTitle: *flags.cluster,
-	}).(*gcloud.Cluster)
+	})
+	cluster, _ = value.(*gcloud.Cluster)
 	return
 }
 
@@ -211,93 +361,321 @@ func filterStrings(options []string, filter string) []string {
 	return results
 }
 
-func readNamespace() (namespace string) {
-	namespace, _ = promptSelection(selectField{
+// clusterKubeconfig returns goproxie-owned kubeconfig path for the cluster,
+// so the user's kubeconfig and its current context are left untouched.
+// Empty path (the default kubeconfig) is returned when opted in via -global_kubeconfig.
+func clusterKubeconfig(projectID string, cluster *gcloud.Cluster) (string, error) {
+	if *flags.globalKubeconfig {
+		return "", nil
+	}
+	dir := path.Join(store.Dir(), "kubeconfigs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return path.Join(dir, fmt.Sprintf("%v_%v_%v", projectID, cluster.Location, cluster.Name)), nil
+}
+
+func readNamespace() (namespace string, err error) {
+	value, err := promptSelection(selectField{
 		titleLoading: "K8S Namespaces",
 		titleChoose:  "K8S Namespace",
-		getOptions: func() (options []selectFieldOption) {
-			for _, namespace := range kubectlNamespacesList() {
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			namespaces, err := kubectlNamespacesList(ctx)
+			for _, namespace := range namespaces {
 				options = append(options, selectFieldOption{title: namespace, value: namespace})
 			}
 			return
 		},
 		valueTitle: *flags.namespace,
-	}).(string)
+	})
+	namespace, _ = value.(string)
 	return
 }
 
-func readPod(namespace string) (pod *kubectl.Pod) {
-	pod, _ = promptSelection(selectField{
+func readPod(namespace string) (pod *kubectl.Pod, err error) {
+	value, err := promptSelection(selectField{
 		titleLoading: "Pods",
 		titleChoose:  "Pod",
-		getOptions: func() (options []selectFieldOption) {
-			for _, pod := range kubectlPodsList(namespace) {
-				options = append(options, selectFieldOption{title: pod.Name, value: pod})
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			pods, err := kubectlPodsList(ctx, namespace, *flags.selector)
+			sortPods(pods)
+			for _, pod := range pods {
+				pod.Selector = *flags.selector
+				options = append(options, selectFieldOption{title: pod.Name, description: podHealth(pod, time.Now()), value: pod, manualOnly: !pod.Ready && !*flags.includeUnready})
 			}
 			return
 		},
-		valueTitle: *flags.pod,
-	}).(*kubectl.Pod)
+		valueTitle:   *flags.pod,
+		manualReason: "not ready, pick one interactively or use -include_unready",
+		// Any ready pod matching the selector will do
+		autoSelect: *flags.selector != "",
+	})
+	pod, _ = value.(*kubectl.Pod)
 	return
 }
 
-func readCloudSQLInstance(projectID string) (instance sqlproxy.CloudSQLInstance) {
+// sortPods orders ready pods first, keeping the order otherwise
+func sortPods(pods []*kubectl.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].Ready && !pods[j].Ready
+	})
+}
+
+// podHealth describes the pod for the picker, e.g. `Running, 2/2 ready, 3 restarts, 5d`
+func podHealth(pod *kubectl.Pod, now time.Time) string {
+	parts := []string{pod.Status, fmt.Sprintf("%v/%v ready", pod.ReadyContainers, len(pod.Containers))}
+	if pod.Restarts == 1 {
+		parts = append(parts, "1 restart")
+	} else if pod.Restarts > 1 {
+		parts = append(parts, fmt.Sprintf("%v restarts", pod.Restarts))
+	}
+	if !pod.StartTime.IsZero() {
+		parts = append(parts, formatAge(now.Sub(pod.StartTime)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatAge formats the duration in its largest unit as kubectl does, e.g. `45s`, `12m`, `5h`, `3d`
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%vs", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%vm", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%vh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%vd", int(age.Hours()/24))
+	}
+}
+
+func readTarget(kind kubectl.TargetKind, namespace string) (target *kubectl.Target, err error) {
+	titles := map[kubectl.TargetKind]string{
+		kubectl.KindService:     "Service",
+		kubectl.KindDeployment:  "Deployment",
+		kubectl.KindStatefulSet: "StatefulSet",
+	}
+	value, err := promptSelection(selectField{
+		titleLoading: fmt.Sprintf("%vs", titles[kind]),
+		titleChoose:  titles[kind],
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			targets, err := kubectlTargetsList(ctx, kind, namespace)
+			for _, target := range targets {
+				ports := []string{}
+				for _, port := range target.Ports {
+					ports = append(ports, strconv.Itoa(port))
+				}
+				options = append(options, selectFieldOption{title: target.Name, description: strings.Join(ports, ", "), value: target})
+			}
+			return
+		},
+		valueTitle: *flags.target,
+	})
+	target, _ = value.(*kubectl.Target)
+	return
+}
+
+// readCloudSQLInstances lets user pick one or more instances, each optionally with its local port
+func readCloudSQLInstances(projectID string) ([]sqlproxy.Listener, error) {
+	listeners, err := sqlproxy.ParseListeners(*flags.sqlInstance)
+	if err != nil {
+		return nil, err
+	}
 	// Allow to connect using only the instance connection name
 	// when user does not have `gcloud projects list` project rights
 	if isBlindCloudSQLConnection() {
-		return sqlproxy.CloudSQLInstance{ConnectionName: *flags.sqlInstance, Type: sqlproxy.TypeUnknown}
+		return listeners, nil
 	}
-	instance, _ = promptSelection(selectField{
-		titleLoading: "Cloud SQL instances",
-		titleChoose:  "Cloud SQL instance",
-		getOptions: func() (options []selectFieldOption) {
-			instances, err := sqlproxy.GetInstancesList([]string{projectID})
+	loadingStart("Loading Cloud SQL instances")
+	ctx, cancel := callContext()
+	instances, err := sqlproxy.GetInstancesList(ctx, []string{projectID})
+	cancel()
+	loadingStop()
+	if err != nil {
+		return nil, err
+	}
+	titles := []string{}
+	for _, instance := range instances {
+		titles = append(titles, instance.ConnectionName)
+	}
+	if len(listeners) == 0 {
+		// Nothing is opened unless picked, aborted prompt must not open all instances
+		picked := []string{}
+		if len(titles) == 1 {
+			picked = titles
+		} else if len(titles) > 1 {
+			prompt := &survey.MultiSelect{
+				Message: "Choose Cloud SQL instances:",
+				Options: titles,
+			}
+			err := survey.AskOne(prompt, &picked, survey.WithValidator(survey.Required))
+			if err == terminal.InterruptErr {
+				return nil, errs.New(errs.Canceled, "Cloud SQL instances prompt", err)
+			}
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
-			for _, instance := range instances {
-				options = append(options, selectFieldOption{title: instance.ConnectionName, value: instance})
+		}
+		if len(picked) == 0 {
+			return nil, errs.New(errs.NotFound, "Cloud SQL instances", fmt.Errorf("none found or picked in project %v", projectID))
+		}
+		for _, title := range picked {
+			listeners = append(listeners, sqlproxy.Listener{Instance: sqlproxy.CloudSQLInstance{ConnectionName: title}})
+		}
+	}
+	// Resolve (possibly partial) names to the listed instances
+	for i, listener := range listeners {
+		filtered := filterStrings(titles, listener.Instance.ConnectionName)
+		if len(filtered) == 0 {
+			return nil, errs.New(errs.NotFound, "Cloud SQL instance "+listener.Instance.ConnectionName, fmt.Errorf("not found in project %v", projectID))
+		}
+		for _, instance := range instances {
+			if instance.ConnectionName == filtered[0] {
+				listeners[i].Instance = instance
+			}
+		}
+		fmt.Printf("Choose Cloud SQL instance: %v\n", filtered[0])
+	}
+	return listeners, nil
+}
+
+// readCloudSQLLocalPorts picks distinct local ports of instances without one.
+// Unix socket replaces TCP port unless the port is requested too.
+func readCloudSQLLocalPorts(listeners []sqlproxy.Listener) error {
+	if len(listeners) > 1 && *flags.localPort != "" && *flags.localPort != localPortAuto {
+		return errors.New("-local_port is ambiguous with multiple Cloud SQL instances, use -sql_instance=<name>=<port>,...")
+	}
+	taken := map[int]bool{}
+	for _, listener := range listeners {
+		taken[listener.LocalPort] = listener.LocalPort != 0
+	}
+	for i, listener := range listeners {
+		if listener.LocalPort != 0 || (*flags.sqlSocketDir != "" && *flags.localPort == "") {
+			continue
+		}
+		guessedPort := guessLocalPort(listener.Instance.DefaultPort, listener.Instance.ConnectionName)
+		title := "local port"
+		if len(listeners) > 1 {
+			title = fmt.Sprintf("local port of %v", listener.Instance.ConnectionName)
+		}
+		localPort, err := readLocalPortTitled(title, guessedPort, taken)
+		if err != nil {
+			return err
+		}
+		if taken[localPort] {
+			return fmt.Errorf("Local port %v is already used by another Cloud SQL instance", localPort)
+		}
+		taken[localPort] = true
+		listeners[i].LocalPort = localPort
+	}
+	return nil
+}
+
+// readSQLIPTypes validates -sql_ip_type against the instances IP types,
+// lets user pick the preferred one when a single instance has multiple and none is requested
+func readSQLIPTypes(listeners []sqlproxy.Listener) ([]sqlproxy.IPType, error) {
+	requested, err := sqlproxy.ParseIPTypes(*flags.sqlIPType)
+	if err != nil {
+		return nil, err
+	}
+	for _, listener := range listeners {
+		// Blind connection, instance metadata is unknown
+		if requested == nil || len(listener.Instance.IPTypes) == 0 {
+			continue
+		}
+		if err := sqlproxy.ValidateIPTypes(requested, listener.Instance.IPTypes); err != nil {
+			return nil, fmt.Errorf("%v: %v", listener.Instance.ConnectionName, err)
+		}
+	}
+	if requested != nil || len(listeners) != 1 || len(listeners[0].Instance.IPTypes) < 2 {
+		return requested, nil
+	}
+	instance := listeners[0].Instance
+	value, err := promptSelection(selectField{
+		titleLoading: "Cloud SQL IP types",
+		titleChoose:  "Cloud SQL IP type",
+		getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
+			// Preferred type first, others as fallback
+			for _, preferred := range instance.IPTypes {
+				ipTypes := []sqlproxy.IPType{preferred}
+				for _, ipType := range instance.IPTypes {
+					if ipType != preferred {
+						ipTypes = append(ipTypes, ipType)
+					}
+				}
+				options = append(options, selectFieldOption{title: sqlproxy.FormatIPTypes(ipTypes), value: ipTypes})
 			}
 			return
 		},
-		valueTitle: *flags.sqlInstance,
-	}).(sqlproxy.CloudSQLInstance)
-	return
+	})
+	ipTypes, _ := value.([]sqlproxy.IPType)
+	return ipTypes, err
 }
 
-func readLocalPort(defaultPort int) int {
-	port := "3000"
-	if *flags.localPort != "" {
+// localPortAuto is -local_port value picking the first free port
+const localPortAuto = "auto"
+
+// guessLocalPort suggests local port by the configured rules matching any of the names,
+// falls back to the given port (e.g. remote or database default port) and 3000.
+func guessLocalPort(fallback int, names ...string) int {
+	rules := []ports.Rule{}
+	if err := store.UnmarshalKey(ports.KeyRules, &rules); err != nil {
+		log.Printf("Invalid %v setting: %v", ports.KeyRules, err)
+	}
+	if port := ports.Guess(append(rules, ports.DefaultRules...), names...); port != 0 {
+		return port
+	}
+	if fallback != 0 {
+		return fallback
+	}
+	return ports.DefaultPort
+}
+
+// readLocalPort picks the first free port from the guess as the default
+func readLocalPort(guessedPort int) (int, error) {
+	return readLocalPortTitled("local port", guessedPort, nil)
+}
+
+// readLocalPortTitled does not suggest ports taken by other listeners of the same proxy
+func readLocalPortTitled(title string, guessedPort int, taken map[int]bool) (int, error) {
+	port := strconv.Itoa(guessedPort)
+	if *flags.localPort == "" || *flags.localPort == localPortAuto {
+		freePort, err := ports.FindFreeExcept(*flags.bindAddress, guessedPort, taken)
+		if err != nil {
+			return 0, err
+		}
+		port = strconv.Itoa(freePort)
+	}
+	if *flags.localPort == localPortAuto {
+		fmt.Printf("Choose %v: %v\n", title, port)
+	} else if *flags.localPort != "" {
 		port = *flags.localPort
-		fmt.Printf("Choose local port: %v\n", port)
+		fmt.Printf("Choose %v: %v\n", title, port)
 	} else {
 		prompt := &survey.Input{
-			Message: "Choose local port:",
-			Default: strconv.Itoa(defaultPort),
+			Message: fmt.Sprintf("Choose %v:", title),
+			Default: port,
 		}
 		survey.AskOne(prompt, &port)
 	}
-	n, err := strconv.Atoi(port)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return n
+	return strconv.Atoi(port)
 }
 
-func readRemotePort(containerPorts []int) (port int) {
+func readRemotePort(containerPorts []int) (port int, err error) {
 	if len(containerPorts) > 0 {
-		port, _ = promptSelection(selectField{
+		value, err := promptSelection(selectField{
 			titleLoading: "Remote ports",
 			titleChoose:  "Remote port",
-			getOptions: func() (options []selectFieldOption) {
+			getOptions: func(ctx context.Context) (options []selectFieldOption, err error) {
 				for _, port := range containerPorts {
 					options = append(options, selectFieldOption{title: strconv.Itoa(port), value: port})
 				}
 				return
 			},
 			valueTitle: *flags.remotePort,
-		}).(int)
-		return
+		})
+		port, _ = value.(int)
+		return port, err
 	}
 	pickedPort := "3000"
 	if *flags.remotePort != "" {
@@ -310,30 +688,126 @@ func readRemotePort(containerPorts []int) (port int) {
 		}
 		survey.AskOne(prompt, &pickedPort)
 	}
-	n, err := strconv.Atoi(pickedPort)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return n
+	return strconv.Atoi(pickedPort)
 }
 
-func readArguments(index int) {
+// readArguments parses options from os.Args[index:] and returns the remaining positional arguments
+func readArguments(index int) []string {
 	flagSet := flag.NewFlagSet("", flag.ExitOnError)
 	gcloudPath := flagSet.String("gcloud_path", "gcloud", "gcloud binary path")
 	kubectlPath := flagSet.String("kubectl_path", "kubectl", "kubectl binary path")
+	flags.forwarder = flagSet.String("forwarder", kubectl.ForwarderNative, "Pod port-forward implementation: native (in-process via Kubernetes API) or kubectl")
 	flags.project = flagSet.String("project", "", "Auto GCP Project pick")
 	flags.proxyType = flagSet.String("proxy_type", "", "Auto Proxy type pick")
 	flags.cluster = flagSet.String("cluster", "", "Auto Cluster pick")
 	flags.namespace = flagSet.String("namespace", "", "Auto Namespace pick")
-	flags.pod = flagSet.String("pod", "", "Auto Pod pick")
-	flags.localPort = flagSet.String("local_port", "", "Auto Local port pick")
+	flags.pod = flagSet.String("pod", "", "Auto Pod pick, only ready pods are picked unless -include_unready is set")
+	flags.includeUnready = flagSet.Bool("include_unready", false, "Auto pick pods that are not Running and Ready, e.g. to debug a crashing pod")
+	flags.selector = flagSet.String("selector", "", "Label selector of pods, e.g. app=api,component=worker. A ready matching pod is picked and replaced on reconnect, recorded in history instead of -pod")
+	flags.target = flagSet.String("target", "", "Auto Service/Deployment/StatefulSet pick, see -proxy_type")
+	flags.localPort = flagSet.String("local_port", "", "Auto Local port pick, auto picks the first free port guessed from pod or instance")
 	flags.remotePort = flagSet.String("remote_port", "", "Auto Remote port pick")
 	flags.noSave = flagSet.Bool("no-save", false, "Don't save invocation to history")
-	flags.sqlInstance = flagSet.String("sql_instance", "", "Cloud SQL Instance in form project:region:instance-name. Can be used if you dont have permissions to list the GCP project.")
+	flags.sqlInstance = flagSet.String("sql_instance", "", "Cloud SQL Instance in form project:region:instance-name. Comma separated for multiple instances, each optionally with local port, e.g. a:b:c=5432,a:b:d=5433. Can be used if you dont have permissions to list the GCP project.")
+	flags.sqlIAMAuth = flagSet.Bool("sql_iam_auth", false, "Cloud SQL IAM database authentication, log in to the database with your Google identity")
+	flags.sqlIPType = flagSet.String("sql_ip_type", "", "Comma separated Cloud SQL IP types tried in order: PUBLIC, PRIVATE, PSC (Private Service Connect), default PUBLIC,PRIVATE")
+	flags.sqlMaxConns = flagSet.Uint64("sql_max_connections", 0, "Maximum of open Cloud SQL connections, new connections wait in the queue. 0 means no limit")
+	flags.sqlTermTimeout = flagSet.Duration("sql_term_timeout", 0, "How long to wait for open Cloud SQL connections to close on Ctrl+C, e.g. 30s. Second Ctrl+C terminates immediately")
+	flags.sqlSocketDir = flagSet.String("sql_socket_dir", "", "Directory of Cloud SQL Unix sockets <dir>/<connection name>, replaces TCP port unless -local_port is set")
+	flags.globalKubeconfig = flagSet.Bool("global_kubeconfig", false, "Write cluster credentials to the default kubeconfig and switch its current context")
+	flags.bindAddress = flagSet.String("bind_address", "", fmt.Sprintf("Address local ports are bound to, e.g. 127.0.0.1, ::1 or 0.0.0.0 to expose to the network. Defaults to %v store setting or %v", KeyBindAddress, defaultBindAddress))
+	flags.name = flagSet.String("name", "", "Tunnel name used in prefixed output of `up`")
+	flags.metricsAddr = flagSet.String("metrics_addr", "", "Address of HTTP listener exposing Prometheus metrics on /metrics, e.g. 127.0.0.1:9090")
+	flags.healthAddr = flagSet.String("health_addr", "", "Address of HTTP listener exposing readiness of tunnels on /health, e.g. 127.0.0.1:9090")
+	flags.waitReady = flagSet.Bool("wait_ready", false, "Make `start` block until started tunnels are ready")
+	flags.waitTimeout = flagSet.Duration("wait_timeout", time.Minute, "How long `wait`, `start -wait_ready`, `exec` and `connect` wait for tunnels to be ready")
+	flags.sqlUser = flagSet.String("sql_user", "", "Database user of `connect`, stored as the instance default")
+	flags.output = flagSet.String("o", outputTable, "Output format of `list`: table, json or yaml")
+	flags.sqlDatabase = flagSet.String("sql_database", "", "Database of `connect`, stored as the instance default")
+	flags.timeout = flagSet.Duration("timeout", time.Minute, "Timeout of each gcloud, kubectl and Cloud SQL API call while loading, 0 disables it")
+	flags.stats = flagSet.Duration("stats", 0, "Log connections statistics periodically, e.g. 1m")
 
 	flagSet.Parse(os.Args[index:])
+	flags.setArgs = []string{}
+	flagSet.Visit(func(f *flag.Flag) {
+		flags.setArgs = append(flags.setArgs, fmt.Sprintf("-%v=%v", f.Name, f.Value))
+	})
 	gcloud.SetGcloudPath(*gcloudPath)
 	kubectl.SetKubectlPath(*kubectlPath)
+	if err := kubectl.SetForwarder(*flags.forwarder); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -forwarder: %v\n", err)
+		os.Exit(2)
+	}
+	if err := kubectl.ValidateSelector(*flags.selector); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -selector: %v\n", err)
+		os.Exit(2)
+	}
+	return flagSet.Args()
+}
+
+// serveEndpoints exposes metrics and health endpoints, sharing the listener when both addresses are equal
+func serveEndpoints() error {
+	muxes := map[string]*http.ServeMux{}
+	handle := func(address string, pattern string, handler http.Handler) {
+		if address == "" {
+			return
+		}
+		if muxes[address] == nil {
+			muxes[address] = http.NewServeMux()
+		}
+		muxes[address].Handle(pattern, handler)
+	}
+	handle(*flags.metricsAddr, "/metrics", metrics.Handler())
+	handle(*flags.healthAddr, "/health", health.Handler())
+	for address, mux := range muxes {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		go http.Serve(listener, mux)
+	}
+	return nil
+}
+
+// printStats prints connections summary of pod forwards, those of kubectl forwarder are not tracked.
+// Cloud SQL proxy prints its own on exit.
+func printStats() {
+	if *flags.forwarder == kubectl.ForwarderNative {
+		stats.Default.WriteSummary(os.Stdout)
+	}
+}
+
+// KeyBindAddress defines the store setting of default bind address
+const KeyBindAddress = "settings.bind_address"
+
+// Loopback by default, so tunnels are not exposed to the local network
+const defaultBindAddress = "127.0.0.1"
+
+// initBindAddress applies -bind_address, store setting or the default to all proxy types
+func initBindAddress() error {
+	if *flags.bindAddress == "" {
+		if stored, ok := store.Get(KeyBindAddress).(string); ok && stored != "" {
+			*flags.bindAddress = stored
+		} else {
+			*flags.bindAddress = defaultBindAddress
+		}
+	}
+	if net.ParseIP(*flags.bindAddress) == nil {
+		return fmt.Errorf("Invalid bind address %q, IP address expected", *flags.bindAddress)
+	}
+	kubectl.SetBindAddress(*flags.bindAddress)
+	sqlproxy.SetBindAddress(*flags.bindAddress)
+	return nil
+}
+
+// sqlProxyOptions returns Cloud SQL proxy options set by flags
+func sqlProxyOptions() sqlproxy.ProxyOptions {
+	return sqlproxy.ProxyOptions{
+		IAMAuth:        *flags.sqlIAMAuth,
+		SocketDir:      *flags.sqlSocketDir,
+		MaxConnections: *flags.sqlMaxConns,
+		TermTimeout:    *flags.sqlTermTimeout,
+	}
 }
 
 func isBlindCloudSQLConnection() bool {
@@ -341,15 +815,22 @@ func isBlindCloudSQLConnection() bool {
 }
 
 func main() {
-
+	args := []string{}
 	if len(os.Args) < 2 {
-		readArguments(1)
+		args = readArguments(1)
 	} else {
 		switch os.Args[1] {
-		case "version", "history", "use":
-			readArguments(2)
+		case "list":
+			// Resource is accepted before options too, e.g. `goproxie list pods -o json`
+			if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "-") {
+				args = append([]string{os.Args[2]}, readArguments(3)...)
+			} else {
+				args = readArguments(2)
+			}
+		case "version", "history", "use", "up", "daemon", "start", "stop", "ps", "wait", "exec", "connect":
+			args = readArguments(2)
 		default:
-			readArguments(1)
+			args = readArguments(1)
 		}
 	}
 
@@ -358,59 +839,197 @@ func main() {
 		return
 	}
 
-	store.Initialize()
+	if err := run(args); err != nil {
+		fatal(err)
+	}
+}
+
+// run executes the subcommand or the interactive wizard with parsed arguments
+func run(args []string) error {
+	if err := store.Initialize(); err != nil {
+		return err
+	}
+	if err := initBindAddress(); err != nil {
+		return err
+	}
 	if len(os.Args) > 1 && os.Args[1] == "history" {
-		history.Browse()
-		return
+		return history.Browse()
 	}
 
-	projectID := readProjectID()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "up":
+			if len(args) == 0 {
+				fmt.Println("Usage: goproxie up <profile>")
+				os.Exit(2)
+			}
+			return runProfile(args[0])
+		case "daemon":
+			return runDaemon(args)
+		case "start":
+			return startTunnels(args, *flags.waitReady, *flags.waitTimeout)
+		case "stop":
+			return stopTunnel(args)
+		case "ps":
+			return listTunnels()
+		case "exec":
+			runExec(os.Args[2:])
+			return nil
+		case "connect":
+			runConnect(os.Args[2:])
+			return nil
+		case "list":
+			if len(args) != 1 {
+				fmt.Println(listUsage)
+				os.Exit(2)
+			}
+			return runList(args[0])
+		case "wait":
+			if len(args) == 0 {
+				fmt.Println("Usage: goproxie wait [-wait_timeout=1m] <name>...")
+				os.Exit(2)
+			}
+			return waitTunnels(args, *flags.waitTimeout)
+		}
+	}
+
+	projectID, err := readProjectID()
+	if err != nil {
+		return err
+	}
 	if projectID == "" && !isBlindCloudSQLConnection() {
 		fmt.Println("Could not find any GCP Projects")
-		return
+		return nil
 	}
 
 	if len(os.Args) > 1 && os.Args[1] == "use" {
-		gcloudSetProject(projectID)
+		ctx, cancel := callContext()
+		err := gcloudSetProject(ctx, projectID)
+		cancel()
+		if err != nil {
+			return err
+		}
 		fmt.Printf("Set gcloud default project to: %s", projectID)
-		return
+		return nil
 	}
 
 	proxyType := readProxyType()
-	if proxyType == ProxyTypePod {
-		cluster := readCluster(projectID)
+	if *flags.stats > 0 {
+		go stats.Default.LogEvery(*flags.stats, nil)
+	}
+	if err := serveEndpoints(); err != nil {
+		return err
+	}
+	if kind, isTarget := targetKinds[proxyType]; proxyType == ProxyTypePod || isTarget {
+		cluster, err := readCluster(projectID)
+		if err != nil {
+			return err
+		}
 		if cluster == nil {
 			fmt.Println("Could not find any GCP Clusters")
-			return
+			return nil
+		}
+		kubeconfig, err := clusterKubeconfig(projectID, cluster)
+		if err != nil {
+			return err
 		}
 		loadingStart("Loading Cluster credentials")
-		gcloudGetClusterCredentials(projectID, cluster)
+		ctx, cancel := callContext()
+		err = gcloudGetClusterCredentials(ctx, projectID, cluster, kubeconfig)
+		cancel()
 		loadingStop()
-		namespace := readNamespace()
+		if err != nil {
+			return err
+		}
+		kubectl.SetKubeconfig(kubeconfig)
+		namespace, err := readNamespace()
+		if err != nil {
+			return err
+		}
 		if namespace == "" {
 			fmt.Println("Could not find any GCP Clusters")
-			return
+			return nil
+		}
+		if isTarget {
+			target, err := readTarget(kind, namespace)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				fmt.Printf("Could not find any K8S %v in namespace %v", kind, namespace)
+				return nil
+			}
+			remotePort, err := readRemotePort(target.Ports)
+			if err != nil {
+				return err
+			}
+			localPort, err := readLocalPort(guessLocalPort(remotePort, target.Name))
+			if err != nil {
+				return err
+			}
+			if *flags.noSave == false {
+				history.StoreTargetProxy(projectID, cluster, namespace, target, localPort, remotePort, *flags.bindAddress)
+			}
+			ctx, stop := interruptContext()
+			defer stop()
+			if err := kubectlTargetPortForward(ctx, target, localPort, remotePort, namespace); err != nil {
+				return err
+			}
+			printStats()
+			return nil
+		}
+		pod, err := readPod(namespace)
+		if err != nil {
+			return err
+		}
+		if pod == nil && *flags.selector != "" {
+			return errs.New(errs.NotFound, "Pods "+*flags.selector, fmt.Errorf("no pod matches the selector in namespace %v", namespace))
+		}
+		if pod == nil && *flags.pod != "" {
+			// E.g. replayed history of a pod replaced by a rollout
+			return errs.New(errs.NotFound, "Pod "+*flags.pod, fmt.Errorf("no pod matches in namespace %v", namespace))
 		}
-		pod := readPod(namespace)
 		if pod == nil {
-			fmt.Printf("Could not find any K8S Pods in namespace %v", namespace)
-			return
+			fmt.Printf("Could not find any K8S Pods in namespace %v\n", namespace)
+			return nil
+		}
+		remotePort, err := readRemotePort(pod.ContainerPorts)
+		if err != nil {
+			return err
+		}
+		localPort, err := readLocalPort(guessLocalPort(remotePort, append([]string{pod.Name}, pod.Images...)...))
+		if err != nil {
+			return err
 		}
-		remotePort := readRemotePort(pod.ContainerPorts)
-		localPort := readLocalPort(remotePort)
 		if *flags.noSave == false {
-			history.StorePodProxy(projectID, cluster, namespace, pod, localPort, remotePort)
+			history.StorePodProxy(projectID, cluster, namespace, pod, localPort, remotePort, *flags.bindAddress)
+		}
+		ctx, stop := interruptContext()
+		defer stop()
+		if err := kubectlPortForward(ctx, pod, localPort, remotePort, namespace); err != nil {
+			return err
 		}
-		kubectlPortForward(pod.Name, localPort, remotePort, namespace)
+		printStats()
+		return nil
 	}
 	if proxyType == ProxyTypeSQL {
-		sqlInstance := readCloudSQLInstance(projectID)
-		localPort := readLocalPort(sqlInstance.DefaultPort)
+		listeners, err := readCloudSQLInstances(projectID)
+		if err != nil {
+			return err
+		}
+		if err := readCloudSQLLocalPorts(listeners); err != nil {
+			return err
+		}
+		options := sqlProxyOptions()
+		if options.IPTypes, err = readSQLIPTypes(listeners); err != nil {
+			return err
+		}
 		if *flags.noSave == false {
-			history.StoreCloudSQLProxy(projectID, sqlInstance, localPort)
+			history.StoreCloudSQLProxy(projectID, listeners, *flags.bindAddress, options)
 		}
-		sqlproxy.CreateProxy(localPort, sqlInstance)
+		return sqlproxy.CreateProxy(listeners, options)
 	}
+	return nil
 
 	// fmt.Println(project_id)
 	// fmt.Println(proxy_type)
@@ -425,13 +1044,6 @@ func main() {
 	//	TODO: Fetch Pods for the selected GCPPROJECT and CLUSTER
 	//	TODO: Prompt user to select the Pod {=POD}
 	//	TODO: Prompt user for local port number
-	// 	TODO (LOW): Prefill the port with the most appropriate and free port on local machine
-	//		Current Node.js/proxie logic is: If it's a VM and contains `mongo` in the name,
-	//		use MongoDB's native port 27017 as the starting point for our guess,
-	//		if it's CloudSQL proxy type then 3306,
-	//		if Pod and name contains `mongo` in the name then 27017,
-	//		3000 otherwise. Then look for first free port by increasing the number - if 3306 is full, try 3307 etc.
-	//		Original logic can be foud here: https://github.com/AckeeCZ/be-scripts/blob/master/src/lib/proxie.ts#L167
 	//	TODO: Prompt user for remote port number
 	//	TODO (LOW): Prefill by the logic above. Remote service almost always uses the default port.
 	//	TODO: Create a kubectl port-forward for the given GCPPROJECT, CLUSTER, POD and ports.
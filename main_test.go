@@ -1,18 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/AckeeCZ/goproxie/internal/errs"
 	"github.com/AckeeCZ/goproxie/internal/gcloud"
 	"github.com/AckeeCZ/goproxie/internal/kubectl"
+	"github.com/AckeeCZ/goproxie/internal/sqlproxy"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
 )
 
 func mockGcloudProjectList(mockedProjects []string) func() {
 	originalFn := gcloudProjectsList
-	gcloudProjectsList = func() []string {
-		return mockedProjects
+	gcloudProjectsList = func(_ context.Context) ([]string, error) {
+		return mockedProjects, nil
 	}
 	return func() {
 		gcloudProjectsList = originalFn
@@ -21,8 +30,8 @@ func mockGcloudProjectList(mockedProjects []string) func() {
 
 func mockKubectlPodsList(mockedPods []*kubectl.Pod) func() {
 	originalFn := kubectlPodsList
-	kubectlPodsList = func(_ string) []*kubectl.Pod {
-		return mockedPods
+	kubectlPodsList = func(_ context.Context, _ string, _ string) ([]*kubectl.Pod, error) {
+		return mockedPods, nil
 	}
 	return func() {
 		kubectlPodsList = originalFn
@@ -31,8 +40,8 @@ func mockKubectlPodsList(mockedPods []*kubectl.Pod) func() {
 
 func mockGcloudContainerClustersList(mockedClusters []*gcloud.Cluster) func() {
 	originalFn := gcloudContainerClustersList
-	gcloudContainerClustersList = func(_ string) []*gcloud.Cluster {
-		return mockedClusters
+	gcloudContainerClustersList = func(_ context.Context, _ string) ([]*gcloud.Cluster, error) {
+		return mockedClusters, nil
 	}
 	return func() {
 		gcloudContainerClustersList = originalFn
@@ -51,8 +60,8 @@ func mockProxyType(proxyType ProxyType) func() {
 
 func mockKubcetlNamespacesList(namespaces []string) func() {
 	originalFn := kubectlNamespacesList
-	kubectlNamespacesList = func() []string {
-		return namespaces
+	kubectlNamespacesList = func(_ context.Context) ([]string, error) {
+		return namespaces, nil
 	}
 	return func() {
 		kubectlNamespacesList = originalFn
@@ -61,7 +70,7 @@ func mockKubcetlNamespacesList(namespaces []string) func() {
 
 func mockGcloudGetClusterCredentials() func() {
 	originalFn := gcloudGetClusterCredentials
-	gcloudGetClusterCredentials = func(_ string, _ *gcloud.Cluster) {}
+	gcloudGetClusterCredentials = func(_ context.Context, _ string, _ *gcloud.Cluster, _ string) error { return nil }
 	return func() {
 		gcloudGetClusterCredentials = originalFn
 	}
@@ -77,11 +86,12 @@ type PortforwardArgs struct {
 func mockKubectlPortForward() func() PortforwardArgs {
 	originalFn := kubectlPortForward
 	callArgs := PortforwardArgs{}
-	kubectlPortForward = func(podName string, localPort int, remotePort int, namespace string) {
-		callArgs.podName = podName
+	kubectlPortForward = func(_ context.Context, pod *kubectl.Pod, localPort int, remotePort int, namespace string) error {
+		callArgs.podName = pod.Name
 		callArgs.localPort = localPort
 		callArgs.remotePort = remotePort
 		callArgs.namespace = namespace
+		return nil
 	}
 	return func() PortforwardArgs {
 		kubectlPortForward = originalFn
@@ -121,7 +131,7 @@ func TestHappyPath(t *testing.T) {
 	unmockAll := mockAll(
 		[]string{"project-1"},
 		[]*kubectl.Pod{
-			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
+			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
 		},
 		[]*gcloud.Cluster{
 			{Name: "cluster-1", Location: "location-1"},
@@ -149,12 +159,12 @@ func TestHappyPath(t *testing.T) {
 	}
 }
 
-func ExampleNoProjects() {
+func Example_noProjects() {
 	resetFlags()
 	unmockAll := mockAll(
 		[]string{},
 		[]*kubectl.Pod{
-			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
+			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
 		},
 		[]*gcloud.Cluster{
 			{Name: "cluster-1", Location: "location-1"},
@@ -168,12 +178,12 @@ func ExampleNoProjects() {
 	// Output: Could not find any GCP Projects
 }
 
-func ExampleNoClusters() {
+func Example_noClusters() {
 	resetFlags()
 	unmockAll := mockAll(
 		[]string{"project-1"},
 		[]*kubectl.Pod{
-			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
+			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
 		},
 		[]*gcloud.Cluster{},
 		"POD",
@@ -187,12 +197,12 @@ func ExampleNoClusters() {
 	// Could not find any GCP Clusters
 }
 
-func ExampleNoNamespaces() {
+func Example_noNamespaces() {
 	resetFlags()
 	unmockAll := mockAll(
 		[]string{"project-1"},
 		[]*kubectl.Pod{
-			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
+			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
 		},
 		[]*gcloud.Cluster{
 			{Name: "cluster-1", Location: "location-1"},
@@ -209,7 +219,7 @@ func ExampleNoNamespaces() {
 	// Could not find any GCP Clusters
 }
 
-func ExampleNoPods() {
+func Example_noPods() {
 	resetFlags()
 	unmockAll := mockAll(
 		[]string{"project-1"},
@@ -235,8 +245,8 @@ func TestAutoselectionFixSuffixes(t *testing.T) {
 	unmockAll := mockAll(
 		[]string{"project-1-suffixed", "project-1"},
 		[]*kubectl.Pod{
-			{Name: "pod-1-suffixed", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
-			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}},
+			{Name: "pod-1-suffixed", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
+			{Name: "pod-1", ContainerPorts: []int{1}, Containers: []string{"container-1"}, Ready: true},
 		},
 		[]*gcloud.Cluster{
 			{Name: "cluster-1-suffixed", Location: "location-1"},
@@ -260,3 +270,415 @@ func TestAutoselectionFixSuffixes(t *testing.T) {
 		t.Errorf("Expected port-forward to be called with namespace=%v, but was called with %v", 1, calledWith.namespace)
 	}
 }
+
+func TestAutoselectionSkipsUnreadyPods(t *testing.T) {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{
+			{Name: "api-1", ContainerPorts: []int{1}, Containers: []string{"api"}, Status: "CrashLoopBackOff", Restarts: 12},
+			{Name: "api-22", ContainerPorts: []int{1}, Containers: []string{"api"}, Status: "Running", ReadyContainers: 1, Ready: true},
+			{Name: "worker-1", ContainerPorts: []int{1}, Containers: []string{"worker"}, Status: "Completed"},
+		},
+		[]*gcloud.Cluster{
+			{Name: "cluster-1", Location: "location-1"},
+		},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	unmockPortForward := mockKubectlPortForward()
+	os.Args = []string{"goproxie", "-pod=api", "-local_port=1234", "-no-save"}
+	if err := run(readArguments(1)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	calledWith := unmockPortForward()
+	if calledWith.podName != "api-22" {
+		t.Errorf("Expected `%v` does not match result `%v`", "api-22", calledWith.podName)
+	}
+
+	resetFlags()
+	os.Args = []string{"goproxie", "-pod=worker", "-local_port=1234", "-no-save"}
+	err := run(readArguments(1))
+	if kind := errs.KindOf(err); kind != errs.NotFound {
+		t.Errorf("Expected `%v` does not match result `%v`", errs.NotFound, kind)
+	}
+	if err == nil || !strings.Contains(err.Error(), "worker-1 (Completed, 0/1 ready)") {
+		t.Errorf("Expected error naming the unready pod, got `%v`", err)
+	}
+
+	resetFlags()
+	unmockPortForward = mockKubectlPortForward()
+	os.Args = []string{"goproxie", "-pod=worker", "-include_unready", "-local_port=1234", "-no-save"}
+	if err := run(readArguments(1)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	calledWith = unmockPortForward()
+	if calledWith.podName != "worker-1" {
+		t.Errorf("Expected `%v` does not match result `%v`", "worker-1", calledWith.podName)
+	}
+
+	resetFlags()
+	os.Args = []string{"goproxie", "-pod=cron", "-local_port=1234", "-no-save"}
+	if kind := errs.KindOf(run(readArguments(1))); kind != errs.NotFound {
+		t.Errorf("Expected `%v` does not match result `%v`", errs.NotFound, kind)
+	}
+}
+
+func TestAutoselectionBySelector(t *testing.T) {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{
+			{Name: "worker-1", ContainerPorts: []int{1}, Containers: []string{"worker"}, Status: "CrashLoopBackOff"},
+			{Name: "worker-2", ContainerPorts: []int{1}, Containers: []string{"worker"}, Status: "Running", ReadyContainers: 1, Ready: true},
+		},
+		[]*gcloud.Cluster{
+			{Name: "cluster-1", Location: "location-1"},
+		},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	unmockPortForward := mockKubectlPortForward()
+	os.Args = []string{"goproxie", "-selector=app=api,component=worker", "-local_port=1234", "-no-save"}
+	if err := run(readArguments(1)); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	calledWith := unmockPortForward()
+	if calledWith.podName != "worker-2" {
+		t.Errorf("Expected `%v` does not match result `%v`", "worker-2", calledWith.podName)
+	}
+
+	resetFlags()
+	unmockPods := mockKubectlPodsList([]*kubectl.Pod{})
+	defer unmockPods()
+	os.Args = []string{"goproxie", "-selector=app=api,component=worker", "-local_port=1234", "-no-save"}
+	if kind := errs.KindOf(run(readArguments(1))); kind != errs.NotFound {
+		t.Errorf("Expected `%v` does not match result `%v`", errs.NotFound, kind)
+	}
+}
+
+func TestPodHealth(t *testing.T) {
+	now := time.Date(2021, 3, 17, 12, 0, 0, 0, time.UTC)
+	items := []struct {
+		pod      *kubectl.Pod
+		expected string
+	}{
+		{&kubectl.Pod{Status: "Running", Containers: []string{"api", "proxy"}, ReadyContainers: 2, StartTime: now.Add(-5 * time.Minute)}, "Running, 2/2 ready, 5m"},
+		{&kubectl.Pod{Status: "CrashLoopBackOff", Containers: []string{"worker"}, Restarts: 12, StartTime: now.Add(-72 * time.Hour)}, "CrashLoopBackOff, 0/1 ready, 12 restarts, 3d"},
+		{&kubectl.Pod{Status: "Running", Containers: []string{"db"}, ReadyContainers: 1, Restarts: 1, StartTime: now.Add(-30 * time.Hour)}, "Running, 1/1 ready, 1 restart, 30h"},
+		{&kubectl.Pod{Status: "Pending", Containers: []string{"db"}}, "Pending, 0/1 ready"},
+	}
+	for _, item := range items {
+		if result := podHealth(item.pod, now); result != item.expected {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+}
+
+func TestSortPods(t *testing.T) {
+	pods := []*kubectl.Pod{{Name: "a"}, {Name: "b", Ready: true}, {Name: "c"}, {Name: "d", Ready: true}}
+	sortPods(pods)
+	result := []string{}
+	for _, pod := range pods {
+		result = append(result, pod.Name)
+	}
+	expected := "b,d,a,c"
+	if strings.Join(result, ",") != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, strings.Join(result, ","))
+	}
+}
+
+func TestServiceTarget(t *testing.T) {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{},
+		[]*gcloud.Cluster{
+			{Name: "cluster-1", Location: "location-1"},
+		},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	unmockProxyType := mockProxyType(ProxyTypeService)
+	defer unmockProxyType()
+	originalTargetsList := kubectlTargetsList
+	kubectlTargetsList = func(_ context.Context, kind kubectl.TargetKind, _ string) ([]*kubectl.Target, error) {
+		return []*kubectl.Target{
+			{Kind: kind, Name: "api-gateway", Ports: []int{80}},
+			{Kind: kind, Name: "api", Ports: []int{80, 443}},
+		}, nil
+	}
+	defer func() { kubectlTargetsList = originalTargetsList }()
+	originalTargetPortForward := kubectlTargetPortForward
+	var calledWith *kubectl.Target
+	calledRemotePort := 0
+	kubectlTargetPortForward = func(_ context.Context, target *kubectl.Target, _ int, remotePort int, _ string) error {
+		calledWith = target
+		calledRemotePort = remotePort
+		return nil
+	}
+	defer func() { kubectlTargetPortForward = originalTargetPortForward }()
+	os.Args = []string{"goproxie", "-target=api", "-remote_port=443", "-local_port=1234", "-no-save"}
+	main()
+	if calledWith == nil || calledWith.Ref() != "service/api" {
+		t.Errorf("Expected port-forward to be called with target=%v, but was called with %v", "service/api", calledWith)
+	}
+	if calledRemotePort != 443 {
+		t.Errorf("Expected port-forward to be called with remotePort=%v, but was called with %v", 443, calledRemotePort)
+	}
+}
+
+func TestRunListError(t *testing.T) {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{},
+		[]*gcloud.Cluster{},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	denied := errs.New(errs.PermissionDenied, "gcloud container clusters list", errors.New("exit status 1"))
+	gcloudContainerClustersList = func(_ context.Context, _ string) ([]*gcloud.Cluster, error) {
+		return nil, denied
+	}
+	os.Args = []string{"goproxie", "-local_port=1234"}
+	err := run(readArguments(1))
+	if err != denied {
+		t.Errorf("Expected `%v` does not match result `%v`", denied, err)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{},
+		[]*gcloud.Cluster{},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	// Hung gcloud is killed once the context is done
+	gcloudContainerClustersList = func(ctx context.Context, _ string) ([]*gcloud.Cluster, error) {
+		<-ctx.Done()
+		return nil, errs.Classify("gcloud container clusters list", "", ctx.Err())
+	}
+	os.Args = []string{"goproxie", "-timeout=10ms", "-local_port=1234"}
+	err := run(readArguments(1))
+	if kind := errs.KindOf(err); kind != errs.Timeout {
+		t.Errorf("Expected `%v` does not match result `%v`", errs.Timeout, kind)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	table := []struct {
+		err      error
+		expected int
+	}{
+		{errors.New("unexpected"), 1},
+		{errs.New(errs.NotAuthenticated, "gcloud projects list", errors.New("exit status 1")), 3},
+		{errs.New(errs.PermissionDenied, "kubectl get pods", errors.New("exit status 1")), 4},
+		{errs.New(errs.NotFound, "GET /projects/p/instances", errors.New("404")), 5},
+		{fmt.Errorf("wrapped: %w", errs.New(errs.BinaryMissing, "kubectl", errors.New("not found"))), 6},
+		{errs.New(errs.Timeout, "port-forward", errors.New("i/o timeout")), 7},
+		{errs.Classify("gcloud projects list", "", context.Canceled), 130},
+	}
+	for _, row := range table {
+		result := exitCode(row.err)
+		if result != row.expected {
+			t.Errorf("Expected `%v` does not match result `%v` for %v", row.expected, result, row.err)
+		}
+	}
+}
+
+func TestExecEnv(t *testing.T) {
+	endpoints := []tunnel.Endpoint{
+		{Address: "127.0.0.1:5432", Instance: "acme:europe-west1:db", DatabaseType: "POSTGRES"},
+		{Address: "/tmp/cloudsql/acme:europe-west1:db/.s.PGSQL.5432", Instance: "acme:europe-west1:db", DatabaseType: "POSTGRES"},
+		{Address: "127.0.0.1:3306", Instance: "acme:europe-west1:mysql", DatabaseType: "MYSQL"},
+	}
+	expectedItems := []string{
+		"GOPROXIE_HOST=127.0.0.1",
+		"GOPROXIE_PORT=5432",
+		"DATABASE_URL=postgres://127.0.0.1:5432",
+		"PGHOST=127.0.0.1",
+		"PGPORT=5432",
+		"GOPROXIE_SOCKET=/tmp/cloudsql/acme:europe-west1:db/.s.PGSQL.5432",
+	}
+	result := execEnv(endpoints)
+	if len(expectedItems) != len(result) {
+		t.Fatalf("Expected `%v` does not match result `%v`", expectedItems, result)
+	}
+	for i, expectedItem := range expectedItems {
+		if expectedItem != result[i] {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, result[i])
+		}
+	}
+}
+
+func TestSortEndpoints(t *testing.T) {
+	endpoints := []tunnel.Endpoint{
+		{Address: "/tmp/cloudsql/b", Instance: "b"},
+		{Address: "127.0.0.1:5433", Instance: "b"},
+		{Address: "127.0.0.1:5432", Instance: "a"},
+	}
+	sortEndpoints(endpoints, []string{"a", "b", "b"})
+	expectedItems := []string{"127.0.0.1:5432", "127.0.0.1:5433", "/tmp/cloudsql/b"}
+	for i, expectedItem := range expectedItems {
+		if expectedItem != endpoints[i].Address {
+			t.Errorf("Expected `%v` does not match result `%v`", expectedItem, endpoints[i].Address)
+		}
+	}
+}
+
+func TestTunnelInstances(t *testing.T) {
+	items := []struct {
+		sqlInstance  string
+		localPort    string
+		sqlSocketDir string
+		expected     []string
+	}{
+		{"", "auto", "", []string{""}},
+		{"acme:europe-west1:a=5432,acme:europe-west1:b=5433", "", "", []string{"acme:europe-west1:a", "acme:europe-west1:b"}},
+		{"acme:europe-west1:a", "", "/tmp/cloudsql", []string{"acme:europe-west1:a"}},
+		{"acme:europe-west1:a", "auto", "/tmp/cloudsql", []string{"acme:europe-west1:a", "acme:europe-west1:a"}},
+	}
+	for _, item := range items {
+		result, err := tunnelInstances(item.sqlInstance, item.localPort, item.sqlSocketDir)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if strings.Join(result, ",") != strings.Join(item.expected, ",") {
+			t.Errorf("Expected `%v` does not match result `%v`", item.expected, result)
+		}
+	}
+}
+
+func TestCheckEndpoints(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	endpoints := []tunnel.Endpoint{
+		{Address: listener.Addr().String(), Instance: "a"},
+		{Address: "/tmp/cloudsql/b", Instance: "b"},
+	}
+	if err := checkEndpoints(endpoints[:1], 2); err == nil {
+		t.Errorf("Expected error while an endpoint is missing")
+	}
+	if err := checkEndpoints(endpoints, 2); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	listener.Close()
+	if err := checkEndpoints(endpoints, 2); err == nil {
+		t.Errorf("Expected error when the local port is closed")
+	}
+}
+
+func Example_listPods() {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{
+			{Name: "pod-1", AppLabel: "api", ContainerPorts: []int{80, 9090}, Containers: []string{"container-1"}, Images: []string{"nginx"}},
+		},
+		[]*gcloud.Cluster{
+			{Name: "cluster-1", Location: "location-1"},
+		},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	os.Args = []string{"goproxie", "list", "pods", "-project=project-1", "-cluster=cluster-1", "-namespace=namespace-1"}
+	main()
+	// Output:
+	// NAME   APP  CONTAINERS   PORTS    IMAGES
+	// pod-1  api  container-1  80,9090  nginx
+}
+
+func Example_listClustersYAML() {
+	resetFlags()
+	unmockAll := mockAll(
+		[]string{"project-1"},
+		[]*kubectl.Pod{},
+		[]*gcloud.Cluster{
+			{Name: "cluster-1", Location: "location-1"},
+			{Name: "cluster-2", Location: "location-2"},
+		},
+		"POD",
+		[]string{"namespace-1"},
+	)
+	defer unmockAll()
+	os.Args = []string{"goproxie", "list", "-o", "yaml", "-project=project-1", "clusters"}
+	main()
+	// Output:
+	// - location: location-1
+	//   name: cluster-1
+	// - location: location-2
+	//   name: cluster-2
+}
+
+func TestWriteListingJSON(t *testing.T) {
+	result := listing{items: []listedNamespace{{Name: "default"}}}
+	out := &strings.Builder{}
+	if err := writeListing(out, outputJSON, result); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := "[\n  {\n    \"name\": \"default\"\n  }\n]\n"
+	if out.String() != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, out.String())
+	}
+}
+
+func TestCloudSQLLocalPortsSkipTaken(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	bound := listener.Addr().(*net.TCPAddr).Port
+	resetFlags()
+	os.Args = []string{"goproxie", "-local_port=auto", "-bind_address=127.0.0.1", "-no-save"}
+	readArguments(1)
+	listeners := []sqlproxy.Listener{
+		{Instance: sqlproxy.CloudSQLInstance{ConnectionName: "acme:europe-west1:primary", DefaultPort: bound}},
+		{Instance: sqlproxy.CloudSQLInstance{ConnectionName: "acme:europe-west1:replica", DefaultPort: bound}},
+	}
+	if err := readCloudSQLLocalPorts(listeners); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	first, second := listeners[0].LocalPort, listeners[1].LocalPort
+	if first == bound || second == bound || first == second {
+		t.Errorf("Expected distinct free ports other than `%v`, got `%v` and `%v`", bound, first, second)
+	}
+}
+
+func TestStartArguments(t *testing.T) {
+	resetFlags()
+	os.Args = []string{"goproxie", "start", "-wait_timeout", "30s", "-project=acme", "-wait_ready", "-name=api"}
+	args := readArguments(2)
+	if len(args) != 0 {
+		t.Errorf("Expected no positional arguments, got `%v`", args)
+	}
+	if !*flags.waitReady || *flags.waitTimeout != 30*time.Second {
+		t.Errorf("Expected `%v` does not match result `%v %v`", "true 30s", *flags.waitReady, *flags.waitTimeout)
+	}
+	expected := "-name=api -project=acme"
+	if result := strings.Join(tunnelArgs(flags.setArgs), " "); result != expected {
+		t.Errorf("Expected `%v` does not match result `%v`", expected, result)
+	}
+}
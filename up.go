@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/AckeeCZ/goproxie/internal/profile"
+	"github.com/AckeeCZ/goproxie/internal/tunnel"
+)
+
+// runProfile starts all tunnels of the profile as goproxie child processes
+// and blocks until all of them exit. SIGINT/SIGTERM/SIGHUP stops all the tunnels,
+// repeated signal stops them without waiting for open connections.
+func runProfile(name string) error {
+	tunnels, err := profile.Load(name)
+	if err != nil {
+		return err
+	}
+	processes := []*tunnel.Process{}
+	stopAll := func() {
+		for _, process := range processes {
+			process.Stop()
+		}
+	}
+	for _, t := range tunnels {
+		// Own process group, so Ctrl+C reaches the tunnels only via stopAll, once per signal
+		process, err := tunnel.StartWithProcAttr(os.Args[0], t.Name, t.Args, os.Stdout, processGroupProcAttr())
+		if err != nil {
+			stopAll()
+			return err
+		}
+		processes = append(processes, process)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		fmt.Println("Stopping all tunnels, send the signal again to stop them immediately")
+		stopAll()
+		// Second interrupt makes Cloud SQL tunnels close open connections without the drain
+		for range signals {
+			stopAll()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(len(processes))
+	for _, process := range processes {
+		process := process
+		go func() {
+			defer wg.Done()
+			if err := process.Wait(); err != nil {
+				fmt.Printf("[%v] Tunnel exited: %v\n", process.Name, err)
+				return
+			}
+			fmt.Printf("[%v] Tunnel closed\n", process.Name)
+		}()
+	}
+	wg.Wait()
+	return nil
+}